@@ -18,36 +18,200 @@
 package pegasus
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
 	log "github.com/sirupsen/logrus"
 )
 
-// AddNodes implements the add-node command.
-func AddNodes(cluster string, deploy deployment.Deployment, nodeNames []string) error {
+// ScaleOutOptions controls how add-node adds the nodes and copies data to them.
+type ScaleOutOptions struct {
+	// The number of nodes added in a wave, each wave is followed by a rebalance.
+	// 0 means adding all nodes in one wave.
+	BatchSize int
+
+	// How long to wait for the nodes of a wave to join the cluster.
+	JoinTimeout time.Duration
+
+	// The maximum number of secondaries added to a node at a time during rebalance.
+	// 0 means unlimited.
+	MaxAddSecondaryPerNode int
+
+	// The maximum rate (in MB/s) of a node copying data during rebalance. 0 means unlimited.
+	CopyRateMB int
+}
+
+// DefaultScaleOutOptions adds all nodes in one wave without throttling.
+var DefaultScaleOutOptions = ScaleOutOptions{
+	JoinTimeout: nodeJoinTimeoutSecs * time.Second,
+}
+
+// the interval of checking whether the started nodes have joined, changeable in tests
+var joinCheckInterval = time.Second
+
+// AddNodes implements the add-node command. The nodes are added in waves. A wave is
+// rebalanced only if all of its nodes have joined the cluster, otherwise add-node stops.
+func AddNodes(cluster string, deploy deployment.Deployment, nodeNames []string, opts ScaleOutOptions) error {
+	if opts.JoinTimeout <= 0 {
+		return fmt.Errorf("invalid join timeout %s", opts.JoinTimeout)
+	}
 	meta, err := newMeta(cluster, deploy)
 	if err != nil {
 		return err
 	}
 
+	var nodes []*deployment.Node
+	for _, name := range nodeNames {
+		node, err := findReplicaNode(name)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+	}
+
 	if err = meta.SetMetaLevelSteady(); err != nil {
 		return err
 	}
 
-	for _, name := range nodeNames {
-		node, err := findReplicaNode(name)
-		if err != nil {
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(nodes)
+	}
+	waves := (len(nodes) + batchSize - 1) / batchSize
+	for i := 0; i < waves; i++ {
+		end := (i + 1) * batchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		wave := nodes[i*batchSize : end]
+		if waves > 1 {
+			log.Printf("Adding wave %d/%d of %d nodes...", i+1, waves, len(wave))
+		}
+
+		if err := startNodes(meta, deploy, wave, opts.JoinTimeout); err != nil {
+			return err
+		}
+		if err := throttledRebalance(meta, opts); err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// throttledRebalance rebalances the cluster with the data copy throttled, and restores
+// the throttles to the values before it.
+func throttledRebalance(meta metaApi.Meta, opts ScaleOutOptions) (err error) {
+	if opts.MaxAddSecondaryPerNode > 0 {
+		var maxCount int
+		var flowControl bool
+		if maxCount, err = meta.GetAddSecondaryMaxCountForOneNode(); err != nil {
+			return err
+		}
+		if flowControl, err = meta.GetAddSecondaryEnableFlowControl(); err != nil {
+			return err
+		}
+		log.Printf("Limiting %d secondaries added to a node at a time", opts.MaxAddSecondaryPerNode)
+		defer func() {
+			// both are restored even if one fails
+			err = restoreKnob(err, "meta.lb.add_secondary_enable_flow_control", func() error {
+				return meta.SetAddSecondaryEnableFlowControl(flowControl)
+			})
+			err = restoreKnob(err, "meta.lb.add_secondary_max_count_for_one_node", func() error {
+				return meta.SetAddSecondaryMaxCountForOneNode(maxCount)
+			})
+		}()
+		if err := meta.SetAddSecondaryMaxCountForOneNode(opts.MaxAddSecondaryPerNode); err != nil {
+			return err
+		}
+		if err := meta.SetAddSecondaryEnableFlowControl(true); err != nil {
+			return err
+		}
+	}
+	if opts.CopyRateMB > 0 {
+		var copyRate int
+		if copyRate, err = meta.GetReplicaCopyRate(); err != nil {
+			return err
+		}
+		log.Printf("Limiting the data copy rate of a node to %d MB/s", opts.CopyRateMB)
+		defer func() {
+			err = restoreKnob(err, "nfs.max_copy_rate_megabytes", func() error {
+				return meta.SetReplicaCopyRate(copyRate)
+			})
+		}()
+		if err := meta.SetReplicaCopyRate(opts.CopyRateMB); err != nil {
+			return err
+		}
+	}
+
+	return meta.Rebalance(false)
+}
+
+// restoreKnob restores the knob, and returns the error of the operation along with
+// the error of restoring, if any.
+func restoreKnob(opErr error, knob string, restore func() error) error {
+	restoreErr := restore()
+	if restoreErr == nil {
+		return opErr
+	}
+	log.Errorf("failed to restore %s: %s", knob, restoreErr)
+	if opErr == nil {
+		return restoreErr
+	}
+	return fmt.Errorf("%s; also failed to restore %s: %s", opErr, knob, restoreErr)
+}
+
+// startNodes starts the replica nodes by deployment, and waits for them to be alive in meta.
+// The returned error reports every node that failed to start or join.
+func startNodes(meta metaApi.Meta, deploy deployment.Deployment, nodes []*deployment.Node, joinTimeout time.Duration) error {
+	var failures []string
+	pending := map[string]*deployment.Node{}
+	for _, node := range nodes {
 		log.Printf("Starting node %s by deployment...", node.IPPort)
 		if err := deploy.StartNode(*node); err != nil {
-			return err
+			log.Errorf("failed to start node %s: %s", node.IPPort, err)
+			failures = append(failures, fmt.Sprintf("%s: failed to start: %s", node.IPPort, err))
+			continue
 		}
 		log.Print("Starting node by deployment done")
+		pending[node.IPPort] = node
 	}
 
-	if err := meta.Rebalance(false); err != nil {
-		return err
+	if len(pending) > 0 {
+		log.Printf("Waiting for %d nodes to join the cluster...", len(pending))
+		deadline := time.Now().Add(joinTimeout)
+		for {
+			infos, err := meta.ListNodes()
+			if err != nil {
+				return err
+			}
+			for _, info := range infos {
+				addr := info.Address.GetAddress()
+				if _, ok := pending[addr]; ok && info.Status == admin.NodeStatus_NS_ALIVE {
+					log.Printf("Node %s has joined the cluster", addr)
+					delete(pending, addr)
+				}
+			}
+			if len(pending) == 0 || !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(joinCheckInterval)
+		}
+	}
+	// report in the order of the given nodes
+	for _, node := range nodes {
+		if _, ok := pending[node.IPPort]; ok {
+			failures = append(failures, fmt.Sprintf("%s: didn't join the cluster in %s", node.IPPort, joinTimeout))
+		}
 	}
 
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d nodes were not added, rebalance is skipped:\n  %s",
+			len(failures), len(nodes), strings.Join(failures, "\n  "))
+	}
+	log.Printf("All %d nodes have joined the cluster", len(nodes))
 	return nil
 }
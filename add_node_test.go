@@ -0,0 +1,203 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+func TestStartNodes(t *testing.T) {
+	old := joinCheckInterval
+	joinCheckInterval = 10 * time.Millisecond
+	defer func() { joinCheckInterval = old }()
+
+	tests := []struct {
+		name string
+		// the deployment doesn't make the nodes alive
+		neverJoin bool
+		errs      map[string]error
+		listErr   error
+		// the expected error, empty means success
+		err string
+	}{
+		{name: "all joined"},
+		{name: "start failed", errs: map[string]error{"StartNode replica 2": errors.New("no package")},
+			err: "127.0.0.1:34802: failed to start: no package"},
+		{name: "not joined", neverJoin: true, err: "127.0.0.1:34801: didn't join the cluster in 300ms"},
+		{name: "list failed", listErr: errors.New("meta is down"), err: "meta is down"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMeta()
+			m.errs["ListNodes"] = tt.listErr
+			nodes := []*deployment.Node{}
+			for _, n := range []deployment.Node{replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802")} {
+				node := n
+				m.setAlive(node.IPPort, false)
+				nodes = append(nodes, &node)
+			}
+			d := newFakeDeployment(m)
+			if tt.neverJoin {
+				d.meta = nil
+			}
+			for call, err := range tt.errs {
+				d.errs[call] = err
+			}
+
+			// a sub-second timeout is still honored
+			start := time.Now()
+			err := startNodes(m, d, nodes, 300*time.Millisecond)
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Fatalf("startNodes returns after %s", elapsed)
+			}
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// every node is started even if some fails
+			if !equalCalls(d.recorded(), []string{"StartNode replica 1", "StartNode replica 2"}) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+		})
+	}
+}
+
+func TestAddNodesWithInvalidJoinTimeout(t *testing.T) {
+	opts := DefaultScaleOutOptions
+	opts.JoinTimeout = 0
+	if err := AddNodes("onebox", newFakeDeployment(nil), []string{"1"}, opts); err == nil {
+		t.Error("expect error for zero join timeout")
+	}
+}
+
+// throttleCalls are the meta calls of a wave throttled by 2 secondaries and 50 MB/s.
+var throttleCalls = []string{
+	"SetAddSecondaryMaxCountForOneNode 2",
+	"SetAddSecondaryEnableFlowControl true",
+	"SetReplicaCopyRate 50",
+	"Rebalance false",
+	// restored to the values before, in the reverse order
+	"SetReplicaCopyRate 500",
+	"SetAddSecondaryEnableFlowControl false",
+	"SetAddSecondaryMaxCountForOneNode 10",
+}
+
+func newAddNodesTest(t *testing.T) (*fakeMeta, *fakeDeployment, ScaleOutOptions) {
+	old := joinCheckInterval
+	joinCheckInterval = 10 * time.Millisecond
+	t.Cleanup(func() { joinCheckInterval = old })
+
+	m := newFakeMeta()
+	useFakeMeta(t, m)
+	var nodes []deployment.Node
+	for _, n := range []string{"1", "2", "3"} {
+		node := replicaNode(n, "127.0.0.1:3480"+n)
+		m.setAlive(node.IPPort, false)
+		nodes = append(nodes, node)
+	}
+	opts := ScaleOutOptions{BatchSize: 2, JoinTimeout: time.Minute, MaxAddSecondaryPerNode: 2, CopyRateMB: 50}
+	return m, newFakeDeployment(m, nodes...), opts
+}
+
+func TestAddNodes(t *testing.T) {
+	m, d, opts := newAddNodesTest(t)
+	if err := AddNodes("onebox", d, []string{"1", "2", "3"}, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	// each wave is rebalanced before the next one starts
+	if !equalCalls(d.recorded(), []string{"StartNode replica 1", "StartNode replica 2", "StartNode replica 3"}) {
+		t.Errorf("unexpected deployment calls %v", d.recorded())
+	}
+	expected := append([]string{"SetMetaLevelSteady"}, throttleCalls...)
+	expected = append(expected, throttleCalls...)
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+}
+
+func TestAddNodesFailed(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(m *fakeMeta, d *fakeDeployment)
+		// the expected error
+		err         string
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name:        "second wave fails to start",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) { d.errs["StartNode replica 3"] = errors.New("no package") },
+			err:         "no package",
+			deployCalls: []string{"StartNode replica 1", "StartNode replica 2", "StartNode replica 3"},
+			metaCalls:   append([]string{"SetMetaLevelSteady"}, throttleCalls...),
+		},
+		{
+			name:        "rebalance fails",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) { m.errs["Rebalance"] = errors.New("stalled") },
+			err:         "stalled",
+			deployCalls: []string{"StartNode replica 1", "StartNode replica 2"},
+			metaCalls:   append([]string{"SetMetaLevelSteady"}, throttleCalls...),
+		},
+		{
+			// nothing is changed if the current values are unknown
+			name:        "copy rate unknown",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) { m.errs["GetReplicaCopyRate"] = errors.New("differs") },
+			err:         "differs",
+			deployCalls: []string{"StartNode replica 1", "StartNode replica 2"},
+			metaCalls: []string{"SetMetaLevelSteady", "SetAddSecondaryMaxCountForOneNode 2", "SetAddSecondaryEnableFlowControl true",
+				"SetAddSecondaryEnableFlowControl false", "SetAddSecondaryMaxCountForOneNode 10"},
+		},
+		{
+			// the max count is restored even if the flow control fails to be restored
+			name: "restore fails",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["SetAddSecondaryEnableFlowControl"] = errors.New("timeout")
+			},
+			err:         "timeout; also failed to restore meta.lb.add_secondary_enable_flow_control: timeout",
+			deployCalls: []string{"StartNode replica 1", "StartNode replica 2"},
+			metaCalls: []string{"SetMetaLevelSteady", "SetAddSecondaryMaxCountForOneNode 2",
+				"SetAddSecondaryEnableFlowControl true", "SetAddSecondaryEnableFlowControl false",
+				"SetAddSecondaryMaxCountForOneNode 10"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, d, opts := newAddNodesTest(t)
+			tt.prepare(m, d)
+
+			err := AddNodes("onebox", d, []string{"1", "2", "3"}, opts)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package audit keeps an append-only local record of every cluster-mutating action,
+// in JSON Lines format.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The types of records.
+const (
+	TypeBegin      = "begin"
+	TypeEnd        = "end"
+	TypeMeta       = "meta"
+	TypeDeployment = "deployment"
+)
+
+// Record is a line in the audit log.
+type Record struct {
+	Time time.Time `json:"time"`
+
+	// OpID identifies the operation (a run of command) that the record belongs to.
+	OpID     string   `json:"op_id"`
+	Operator string   `json:"operator"`
+	Cluster  string   `json:"cluster"`
+	Command  string   `json:"command"`
+	Nodes    []string `json:"nodes,omitempty"`
+
+	Type string `json:"type"`
+
+	// The meta knob or the deployment call, empty for begin/end records.
+	Action string   `json:"action,omitempty"`
+	Args   []string `json:"args,omitempty"`
+
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// Logger appends the records of an operation to the audit log.
+// A nil Logger is valid and records nothing.
+type Logger struct {
+	mu   sync.Mutex
+	file *os.File
+
+	begin time.Time
+	base  Record
+}
+
+// DefaultPath returns the default location of the audit log.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "audit.log"
+	}
+	return filepath.Join(home, ".pegasus-cluster-cli", "audit.log")
+}
+
+// Begin opens the audit log at path, and records the beginning of the operation.
+func Begin(path string, operator string, cluster string, command string, nodes []string) (*Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	now := time.Now()
+	l := &Logger{
+		file:  f,
+		begin: now,
+		base: Record{
+			OpID:     fmt.Sprintf("%s-%d", now.Format("20060102150405"), os.Getpid()),
+			Operator: operator,
+			Cluster:  cluster,
+			Command:  command,
+			Nodes:    nodes,
+		},
+	}
+	if err := l.write(TypeBegin, "", nil, now, nil); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends a record of the action that started at `start` and ended with `actionErr`.
+func (l *Logger) Record(typ string, action string, args []string, start time.Time, actionErr error) {
+	if l == nil {
+		return
+	}
+	if err := l.write(typ, action, args, start, actionErr); err != nil {
+		// auditing must not interrupt the operation
+		fmt.Fprintf(os.Stderr, "failed to write audit log: %s\n", err)
+	}
+}
+
+// End records the outcome of the operation and closes the audit log.
+func (l *Logger) End(opErr error) {
+	if l == nil {
+		return
+	}
+	l.Record(TypeEnd, "", nil, l.begin, opErr)
+	_ = l.file.Close()
+}
+
+func (l *Logger) write(typ string, action string, args []string, start time.Time, actionErr error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r := l.base
+	r.Time = start
+	r.Type = typ
+	r.Action = action
+	r.Args = args
+	r.Success = actionErr == nil
+	if actionErr != nil {
+		r.Error = actionErr.Error()
+	}
+	r.ElapsedMs = time.Since(start).Milliseconds()
+
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,112 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestLogPath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cluster-cli-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	// the directory of the log is created on demand
+	return filepath.Join(dir, "audit", "audit.log")
+}
+
+func TestLoggerRoundTrip(t *testing.T) {
+	path := newTestLogPath(t)
+	l, err := Begin(path, "alice", "onebox", "rolling-update", []string{"1", "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	l.Record(TypeMeta, "meta_level", []string{"steady"}, start, nil)
+	l.Record(TypeDeployment, "rolling_update", []string{"replica", "1", "127.0.0.1:34801"}, start, errors.New("no package"))
+	l.End(errors.New("no package"))
+
+	records, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, r := range records {
+		types = append(types, r.Type)
+		if r.OpID != records[0].OpID || r.Operator != "alice" || r.Cluster != "onebox" ||
+			r.Command != "rolling-update" || strings.Join(r.Nodes, ",") != "1,2" {
+			t.Errorf("the record is not of the operation: %+v", r)
+		}
+	}
+	if strings.Join(types, ",") != "begin,meta,deployment,end" {
+		t.Fatalf("unexpected records %v", types)
+	}
+	if r := records[1]; r.Action != "meta_level" || strings.Join(r.Args, " ") != "steady" || !r.Success || r.Error != "" {
+		t.Errorf("unexpected meta record %+v", r)
+	}
+	if r := records[2]; r.Action != "rolling_update" || r.Success || r.Error != "no package" {
+		t.Errorf("unexpected deployment record %+v", r)
+	}
+
+	ops := ListOperations(records)
+	if len(ops) != 1 {
+		t.Fatalf("unexpected operations %v", ops)
+	}
+	if op := ops[0]; op.Outcome != OutcomeFailed || op.Error != "no package" || op.End.IsZero() || len(op.Records) != 4 {
+		t.Errorf("unexpected operation %+v", op)
+	}
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	l.Record(TypeMeta, "meta_level", []string{"steady"}, time.Now(), nil)
+	l.End(nil)
+}
+
+func TestReadRecords(t *testing.T) {
+	path := newTestLogPath(t)
+	if records, err := ReadRecords(path); err != nil || records != nil {
+		t.Fatalf("a missing log has no record, got %v %v", records, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a crashed process may leave a partial line
+	content := `{"op_id":"a","type":"begin"}
+{"op_id":"a","type":"me
+{"op_id":"a","type":"end","success":true}
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Type != TypeBegin || records[1].Type != TypeEnd {
+		t.Errorf("the malformed line should be skipped, got %+v", records)
+	}
+}
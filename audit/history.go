@@ -0,0 +1,137 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// The outcomes of an operation.
+const (
+	OutcomeSuccess    = "success"
+	OutcomeFailed     = "failed"
+	OutcomeUnfinished = "unfinished"
+)
+
+// Operation summarizes the records of a run of command.
+type Operation struct {
+	OpID     string
+	Operator string
+	Cluster  string
+	Command  string
+	Nodes    []string
+
+	Begin time.Time
+	// zero if the operation has not finished, or was killed
+	End time.Time
+
+	Outcome string
+	Error   string
+
+	Records []Record
+}
+
+// Filter selects the operations. Empty fields match anything.
+type Filter struct {
+	Cluster  string
+	Operator string
+	Command  string
+	Since    time.Time
+	Outcome  string
+}
+
+// Match returns whether the operation satisfies the filter.
+func (f *Filter) Match(op *Operation) bool {
+	if f.Cluster != "" && f.Cluster != op.Cluster {
+		return false
+	}
+	if f.Operator != "" && f.Operator != op.Operator {
+		return false
+	}
+	if f.Command != "" && f.Command != op.Command {
+		return false
+	}
+	if !f.Since.IsZero() && op.Begin.Before(f.Since) {
+		return false
+	}
+	if f.Outcome != "" && f.Outcome != op.Outcome {
+		return false
+	}
+	return true
+}
+
+// ReadRecords reads all records from the audit log. Malformed lines, possibly written
+// by a crashed process, are skipped.
+func ReadRecords(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// ListOperations groups the records by operation, in the order they began.
+func ListOperations(records []Record) []*Operation {
+	var ops []*Operation
+	opMap := map[string]*Operation{}
+	for _, r := range records {
+		op, ok := opMap[r.OpID]
+		if !ok {
+			op = &Operation{
+				OpID:     r.OpID,
+				Operator: r.Operator,
+				Cluster:  r.Cluster,
+				Command:  r.Command,
+				Nodes:    r.Nodes,
+				Begin:    r.Time,
+				Outcome:  OutcomeUnfinished,
+			}
+			opMap[r.OpID] = op
+			ops = append(ops, op)
+		}
+		op.Records = append(op.Records, r)
+		if r.Type == TypeEnd {
+			op.End = r.Time.Add(time.Duration(r.ElapsedMs) * time.Millisecond)
+			if r.Success {
+				op.Outcome = OutcomeSuccess
+			} else {
+				op.Outcome = OutcomeFailed
+				op.Error = r.Error
+			}
+		}
+	}
+	return ops
+}
@@ -0,0 +1,83 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListOperations(t *testing.T) {
+	begin := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	records := []Record{
+		{OpID: "a", Command: "rolling-update", Type: TypeBegin, Time: begin},
+		{OpID: "b", Command: "remove-node", Type: TypeBegin, Time: begin.Add(time.Minute)},
+		{OpID: "a", Type: TypeMeta, Action: "meta_level", Time: begin.Add(time.Second)},
+		{OpID: "b", Type: TypeEnd, Success: true, Time: begin.Add(time.Minute), ElapsedMs: 2000},
+		// killed before the end
+		{OpID: "c", Command: "add-node", Type: TypeBegin, Time: begin.Add(2 * time.Minute)},
+		{OpID: "a", Type: TypeEnd, Error: "timeout", Time: begin, ElapsedMs: 3000},
+	}
+
+	ops := ListOperations(records)
+	if len(ops) != 3 || ops[0].OpID != "a" || ops[1].OpID != "b" || ops[2].OpID != "c" {
+		t.Fatalf("the operations should be in the order they began: %v", ops)
+	}
+	if a := ops[0]; a.Command != "rolling-update" || a.Outcome != OutcomeFailed || a.Error != "timeout" ||
+		!a.End.Equal(begin.Add(3*time.Second)) || len(a.Records) != 3 {
+		t.Errorf("unexpected operation %+v", a)
+	}
+	if b := ops[1]; b.Outcome != OutcomeSuccess || !b.End.Equal(begin.Add(time.Minute+2*time.Second)) {
+		t.Errorf("unexpected operation %+v", b)
+	}
+	if c := ops[2]; c.Outcome != OutcomeUnfinished || !c.End.IsZero() {
+		t.Errorf("unexpected operation %+v", c)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	begin := time.Date(2021, 5, 1, 10, 0, 0, 0, time.UTC)
+	op := &Operation{
+		Cluster:  "onebox",
+		Operator: "alice",
+		Command:  "rolling-update",
+		Begin:    begin,
+		Outcome:  OutcomeSuccess,
+	}
+	tests := []struct {
+		name   string
+		filter Filter
+		match  bool
+	}{
+		{name: "empty", filter: Filter{}, match: true},
+		{name: "all fields", match: true, filter: Filter{Cluster: "onebox", Operator: "alice",
+			Command: "rolling-update", Since: begin, Outcome: OutcomeSuccess}},
+		{name: "cluster", filter: Filter{Cluster: "other"}},
+		{name: "operator", filter: Filter{Operator: "bob"}},
+		{name: "command", filter: Filter{Command: "remove-node"}},
+		{name: "since", filter: Filter{Since: begin.Add(time.Second)}},
+		{name: "outcome", filter: Filter{Outcome: OutcomeFailed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filter.Match(op) != tt.match {
+				t.Errorf("expect match %v", tt.match)
+			}
+		})
+	}
+}
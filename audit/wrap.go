@@ -0,0 +1,202 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// auditedMeta records the calls that change the cluster state, and forwards
+// the read-only calls to the underlying Meta as they are.
+type auditedMeta struct {
+	meta.Meta
+
+	log *Logger
+}
+
+// WrapMeta returns a Meta that records every knob change into the audit log.
+func WrapMeta(m meta.Meta, l *Logger) meta.Meta {
+	if l == nil {
+		return m
+	}
+	return &auditedMeta{Meta: m, log: l}
+}
+
+func (m *auditedMeta) record(action string, args []string, call func() error) error {
+	start := time.Now()
+	err := call()
+	m.log.Record(TypeMeta, action, args, start, err)
+	return err
+}
+
+func (m *auditedMeta) SetMetaLevelSteady() error {
+	return m.record("meta_level", []string{"steady"}, m.Meta.SetMetaLevelSteady)
+}
+
+func (m *auditedMeta) SetMetaLevelLively() error {
+	return m.record("meta_level", []string{"lively"}, m.Meta.SetMetaLevelLively)
+}
+
+func (m *auditedMeta) SetMetaLevelBlind() error {
+	return m.record("meta_level", []string{"blind"}, m.Meta.SetMetaLevelBlind)
+}
+
+func (m *auditedMeta) SetAddSecondaryMaxCountForOneNode(num int) error {
+	return m.record("meta.lb.add_secondary_max_count_for_one_node", []string{fmt.Sprint(num)}, func() error {
+		return m.Meta.SetAddSecondaryMaxCountForOneNode(num)
+	})
+}
+
+func (m *auditedMeta) ResetDefaultAddSecondaryMaxCountForOneNode() error {
+	return m.record("meta.lb.add_secondary_max_count_for_one_node", []string{"DEFAULT"},
+		m.Meta.ResetDefaultAddSecondaryMaxCountForOneNode)
+}
+
+func (m *auditedMeta) SetAddSecondaryEnableFlowControl(enable bool) error {
+	return m.record("meta.lb.add_secondary_enable_flow_control", []string{fmt.Sprint(enable)}, func() error {
+		return m.Meta.SetAddSecondaryEnableFlowControl(enable)
+	})
+}
+
+func (m *auditedMeta) SetReplicaCopyRate(megabytes int) error {
+	return m.record("nfs.max_copy_rate_megabytes", []string{fmt.Sprint(megabytes)}, func() error {
+		return m.Meta.SetReplicaCopyRate(megabytes)
+	})
+}
+
+func (m *auditedMeta) SetNodeLivePercentageZero() error {
+	return m.record("meta.live_percentage", []string{"0"}, m.Meta.SetNodeLivePercentageZero)
+}
+
+func (m *auditedMeta) AssignSecondaryBlackList(blacklist string) error {
+	return m.record("meta.lb.assign_secondary_black_list", []string{blacklist}, func() error {
+		return m.Meta.AssignSecondaryBlackList(blacklist)
+	})
+}
+
+func (m *auditedMeta) SetAssignDelayMs(delayMs int) error {
+	return m.record("meta.lb.assign_delay_ms", []string{fmt.Sprint(delayMs)}, func() error {
+		return m.Meta.SetAssignDelayMs(delayMs)
+	})
+}
+
+func (m *auditedMeta) ResetDefaultAssignDelayMs() error {
+	return m.record("meta.lb.assign_delay_ms", []string{"DEFAULT"}, m.Meta.ResetDefaultAssignDelayMs)
+}
+
+func (m *auditedMeta) MigratePrimariesOut(n *util.PegasusNode) error {
+	return m.record("migrate_primaries_out", []string{n.TCPAddr()}, func() error {
+		return m.Meta.MigratePrimariesOut(n)
+	})
+}
+
+func (m *auditedMeta) DowngradeNodeWithDetails(n *util.PegasusNode) (downgradedParts []*base.Gpid, err error) {
+	err = m.record("downgrade_node", []string{n.TCPAddr()}, func() error {
+		downgradedParts, err = m.Meta.DowngradeNodeWithDetails(n)
+		return err
+	})
+	return downgradedParts, err
+}
+
+func (m *auditedMeta) SetOnlyMovePrimary(enable bool) error {
+	return m.record("meta.lb.only_move_primary", []string{fmt.Sprint(enable)}, func() error {
+		return m.Meta.SetOnlyMovePrimary(enable)
+	})
+}
+
+// Rebalance drives the rebalance through the audited setters, so the knobs changed
+// meanwhile, e.g. the meta level, are recorded as well.
+func (m *auditedMeta) Rebalance(primaryOnly bool) error {
+	return m.record("rebalance", []string{fmt.Sprintf("primary_only=%v", primaryOnly)}, func() error {
+		return meta.RebalanceWith(m, primaryOnly)
+	})
+}
+
+func (m *auditedMeta) MovePrimary(gpid *base.Gpid, from *util.PegasusNode, to *util.PegasusNode) error {
+	return m.record("move_primary", []string{gpid.String(), from.TCPAddr(), to.TCPAddr()}, func() error {
+		return m.Meta.MovePrimary(gpid, from, to)
+	})
+}
+
+func (m *auditedMeta) UpdateAppEnvs(tableName string, envs map[string]string) error {
+	var args []string
+	for k, v := range envs {
+		args = append(args, k+"="+v)
+	}
+	return m.record("update_app_envs", append([]string{tableName}, args...), func() error {
+		return m.Meta.UpdateAppEnvs(tableName, envs)
+	})
+}
+
+func (m *auditedMeta) DelAppEnvs(tableName string, keys []string) error {
+	return m.record("del_app_envs", append([]string{tableName}, keys...), func() error {
+		return m.Meta.DelAppEnvs(tableName, keys)
+	})
+}
+
+func (m *auditedMeta) CreateTable(tableName string, partitionCount int) error {
+	return m.record("create_table", []string{tableName, fmt.Sprint(partitionCount)}, func() error {
+		return m.Meta.CreateTable(tableName, partitionCount)
+	})
+}
+
+// auditedDeployment records every call that operates a node.
+type auditedDeployment struct {
+	deployment.Deployment
+
+	log *Logger
+}
+
+// WrapDeployment returns a Deployment that records every node operation into the audit log.
+func WrapDeployment(d deployment.Deployment, l *Logger) deployment.Deployment {
+	if l == nil {
+		return d
+	}
+	return &auditedDeployment{Deployment: d, log: l}
+}
+
+func (d *auditedDeployment) record(action string, node deployment.Node, call func(deployment.Node) error) error {
+	start := time.Now()
+	err := call(node)
+	d.log.Record(TypeDeployment, action, []string{node.Job.String(), node.Name, node.IPPort}, start, err)
+	return err
+}
+
+func (d *auditedDeployment) StartNode(node deployment.Node) error {
+	return d.record("start_node", node, d.Deployment.StartNode)
+}
+
+func (d *auditedDeployment) StopNode(node deployment.Node) error {
+	return d.record("stop_node", node, d.Deployment.StopNode)
+}
+
+func (d *auditedDeployment) RestartNode(node deployment.Node) error {
+	return d.record("restart_node", node, func(n deployment.Node) error {
+		return deployment.RestartNode(d.Deployment, n)
+	})
+}
+
+func (d *auditedDeployment) RollingUpdate(node deployment.Node) error {
+	return d.record("rolling_update", node, d.Deployment.RollingUpdate)
+}
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// fakeMeta implements the setters driving a rebalance, other methods panic.
+type fakeMeta struct {
+	meta.Meta
+
+	errs map[string]error
+}
+
+func (m *fakeMeta) SetOnlyMovePrimary(enable bool) error { return m.errs["SetOnlyMovePrimary"] }
+func (m *fakeMeta) SetMetaLevelLively() error            { return m.errs["SetMetaLevelLively"] }
+func (m *fakeMeta) SetMetaLevelSteady() error            { return m.errs["SetMetaLevelSteady"] }
+func (m *fakeMeta) WaitBalanced(primaryOnly bool) error  { return m.errs["WaitBalanced"] }
+func (m *fakeMeta) SetAssignDelayMs(delayMs int) error   { return m.errs["SetAssignDelayMs"] }
+
+// fakeDeployment is not a deployment.Restarter.
+type fakeDeployment struct {
+	deployment.Deployment
+
+	errs map[string]error
+}
+
+func (d *fakeDeployment) StartNode(node deployment.Node) error { return d.errs["StartNode"] }
+func (d *fakeDeployment) StopNode(node deployment.Node) error  { return d.errs["StopNode"] }
+
+// actions returns the actions with args of the meta and deployment records.
+func actions(t *testing.T, path string) []string {
+	records, err := ReadRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, r := range records {
+		if r.Type != TypeMeta && r.Type != TypeDeployment {
+			continue
+		}
+		action := strings.Join(append([]string{r.Action}, r.Args...), " ")
+		if !r.Success {
+			action += ": " + r.Error
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func TestWrapMeta(t *testing.T) {
+	path := newTestLogPath(t)
+	l, err := Begin(path, "alice", "onebox", "rebalance", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := WrapMeta(&fakeMeta{errs: map[string]error{"SetAssignDelayMs": errors.New("timeout")}}, l)
+
+	if err := m.Rebalance(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetAssignDelayMs(10); err == nil {
+		t.Fatal("expect the error to be returned as it is")
+	}
+	l.End(nil)
+
+	expected := []string{
+		// the knobs changed during rebalancing are recorded
+		"meta.lb.only_move_primary true",
+		"meta_level lively",
+		"meta_level steady",
+		"meta.lb.only_move_primary false",
+		"rebalance primary_only=true",
+		"meta.lb.assign_delay_ms 10: timeout",
+	}
+	if got := actions(t, path); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected actions %v", got)
+	}
+}
+
+func TestWrapDeployment(t *testing.T) {
+	path := newTestLogPath(t)
+	l, err := Begin(path, "alice", "onebox", "restart-node", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := WrapDeployment(&fakeDeployment{errs: map[string]error{"StopNode": errors.New("no process")}}, l)
+	node := deployment.Node{Job: deployment.JobReplica, Name: "1", IPPort: "127.0.0.1:34801"}
+
+	if err := d.StartNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StopNode(node); err == nil {
+		t.Fatal("expect the error to be returned as it is")
+	}
+	if err := deployment.RestartNode(d, node); err == nil {
+		t.Fatal("expect restarting to fail as the node fails to stop")
+	}
+	l.End(nil)
+
+	expected := []string{
+		"start_node replica 1 127.0.0.1:34801",
+		"stop_node replica 1 127.0.0.1:34801: no process",
+		"restart_node replica 1 127.0.0.1:34801: no process",
+	}
+	if got := actions(t, path); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected actions %v", got)
+	}
+}
+
+func TestWrapWithoutLogger(t *testing.T) {
+	m := &fakeMeta{}
+	if WrapMeta(m, nil) != meta.Meta(m) {
+		t.Error("the meta should not be wrapped without a logger")
+	}
+	d := &fakeDeployment{}
+	if WrapDeployment(d, nil) != deployment.Deployment(d) {
+		t.Error("the deployment should not be wrapped without a logger")
+	}
+}
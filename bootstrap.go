@@ -0,0 +1,106 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// BootstrapOptions controls how a new cluster is verified after it's started.
+type BootstrapOptions struct {
+	// The table created to verify that the cluster serves, empty means no table is created.
+	SmokeTable string
+
+	SmokeTablePartitions int
+}
+
+// DefaultBootstrapOptions creates no smoke-test table.
+var DefaultBootstrapOptions = BootstrapOptions{
+	SmokeTablePartitions: 4,
+}
+
+// Bootstrap implements the bootstrap command, which stands up a new cluster from the nodes
+// in deployment: metas, replicas, then collectors, each waited to be ready before the next.
+func Bootstrap(cluster string, deploy deployment.Deployment, opts BootstrapOptions) error {
+	if err := listAndCacheAllNodes(deploy); err != nil {
+		return err
+	}
+	metas := nodesOfJob(deployment.JobMeta)
+	if len(metas) == 0 {
+		return fmt.Errorf("no meta node of cluster %s is found in deployment", cluster)
+	}
+	replicas := nodesOfJob(deployment.JobReplica)
+	if len(replicas) == 0 {
+		return fmt.Errorf("no replica node of cluster %s is found in deployment", cluster)
+	}
+
+	if err := startJobNodes(deploy, metas); err != nil {
+		return err
+	}
+	meta, err := waitPrimaryMeta(cluster)
+	if err != nil {
+		return err
+	}
+
+	if err := startJobNodes(deploy, replicas); err != nil {
+		return err
+	}
+	if err := waitReplicasAlive(meta, replicas); err != nil {
+		return err
+	}
+
+	if err := startJobNodes(deploy, nodesOfJob(deployment.JobCollector)); err != nil {
+		return err
+	}
+
+	if opts.SmokeTable != "" {
+		if err := createSmokeTable(meta, opts); err != nil {
+			return err
+		}
+	}
+	log.Printf("Cluster %s is bootstrapped with %d metas and %d replicas", cluster, len(metas), len(replicas))
+	return nil
+}
+
+func createSmokeTable(meta metaApi.Meta, opts BootstrapOptions) error {
+	log.Printf("Creating smoke-test table %s with %d partitions...", opts.SmokeTable, opts.SmokeTablePartitions)
+	if err := meta.CreateTable(opts.SmokeTable, opts.SmokeTablePartitions); err != nil {
+		return err
+	}
+	healthy, err := waitFor(func() (bool, error) {
+		info, err := meta.GetTableHealthInfo(opts.SmokeTable)
+		if err != nil {
+			return false, err
+		}
+		log.Debugf("%d/%d partitions of %s are fully healthy", info.FullHealthy, info.PartitionCount, opts.SmokeTable)
+		return info.PartitionCount > 0 && info.FullHealthy == info.PartitionCount, nil
+	}, time.Second, nodeJoinTimeoutSecs)
+	if err != nil {
+		return err
+	}
+	if !healthy {
+		return fmt.Errorf("smoke-test table %s is not healthy in %d seconds", opts.SmokeTable, nodeJoinTimeoutSecs)
+	}
+	log.Printf("Smoke-test table %s becomes healthy", opts.SmokeTable)
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+func TestBootstrap(t *testing.T) {
+	startAll := []string{"StartNode meta m0", "StartNode meta m1", "StartNode replica 1", "StartNode replica 2",
+		"StartNode collector c0"}
+	tests := []struct {
+		name       string
+		smokeTable string
+		prepare    func(m *fakeMeta, d *fakeDeployment)
+		// the expected error, empty means success
+		err         string
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name:        "bootstrapped",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) {},
+			deployCalls: startAll,
+		},
+		{
+			name:        "with smoke table",
+			smokeTable:  "smoke",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) {},
+			deployCalls: startAll,
+			metaCalls:   []string{"CreateTable smoke 4"},
+		},
+		{
+			name: "no replica node",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.nodes = nodesExcept(d.nodes, deployment.JobReplica)
+			},
+			err: "no replica node",
+		},
+		{
+			name: "no meta node",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.nodes = nodesExcept(d.nodes, deployment.JobMeta)
+			},
+			err: "no meta node",
+		},
+		{
+			// fails fast instead of waiting for a primary meta
+			name:        "another cluster",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) { m.cluster = "other" },
+			err:         "other",
+			deployCalls: []string{"StartNode meta m0", "StartNode meta m1"},
+		},
+		{
+			name: "replica fails to start",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.errs["StartNode replica 2"] = errors.New("no package")
+			},
+			err:         "no package",
+			deployCalls: []string{"StartNode meta m0", "StartNode meta m1", "StartNode replica 1", "StartNode replica 2"},
+		},
+		{
+			name:       "smoke table fails to be created",
+			smokeTable: "smoke",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["CreateTable"] = errors.New("table exists")
+			},
+			err:         "table exists",
+			deployCalls: startAll,
+			metaCalls:   []string{"CreateTable smoke 4"},
+		},
+		{
+			name:       "smoke table health unknown",
+			smokeTable: "smoke",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["GetTableHealthInfo"] = errors.New("timeout")
+			},
+			err:         "timeout",
+			deployCalls: startAll,
+			metaCalls:   []string{"CreateTable smoke 4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, d := newClusterTest(t)
+			m.setAlive("127.0.0.1:34801", false)
+			m.setAlive("127.0.0.1:34802", false)
+			tt.prepare(m, d)
+
+			opts := DefaultBootstrapOptions
+			opts.SmokeTable = tt.smokeTable
+			err := Bootstrap("onebox", d, opts)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
+
+func nodesExcept(nodes []deployment.Node, job deployment.JobType) []deployment.Node {
+	var res []deployment.Node
+	for _, n := range nodes {
+		if n.Job != job {
+			res = append(res, n)
+		}
+	}
+	return res
+}
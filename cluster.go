@@ -0,0 +1,225 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// StopCluster implements the `cluster stop` command. It shuts down the whole cluster in
+// the order that no replica is moved: collectors, replicas with the MetaServer blind,
+// the backup metas, and finally the primary meta.
+func StopCluster(cluster string, deploy deployment.Deployment) error {
+	meta, err := newMeta(cluster, deploy)
+	if err != nil {
+		return err
+	}
+	info, err := meta.GetClusterInfo()
+	if err != nil {
+		return err
+	}
+
+	if err := stopJobNodes(deploy, nodesOfJob(deployment.JobCollector)); err != nil {
+		return err
+	}
+
+	log.Print("Setting meta level to blind, so that no partition is cured when replicas stop...")
+	if err := meta.SetMetaLevelBlind(); err != nil {
+		return err
+	}
+	if err := stopJobNodes(deploy, nodesOfJob(deployment.JobReplica)); err != nil {
+		return err
+	}
+
+	var backupMetas, primaryMeta []deployment.Node
+	for _, n := range nodesOfJob(deployment.JobMeta) {
+		if n.IPPort == info.PrimaryMeta {
+			primaryMeta = append(primaryMeta, n)
+		} else {
+			backupMetas = append(backupMetas, n)
+		}
+	}
+	if len(primaryMeta) == 0 {
+		log.Warnf("primary meta %s is not found in deployment", info.PrimaryMeta)
+	}
+	if err := stopJobNodes(deploy, backupMetas); err != nil {
+		return err
+	}
+	if err := stopJobNodes(deploy, primaryMeta); err != nil {
+		return err
+	}
+	log.Printf("Cluster %s is stopped", cluster)
+	return nil
+}
+
+// StartCluster implements the `cluster start` command. It brings the cluster back in the
+// order of metas, replicas and collectors. The MetaServer is kept blind until all replicas
+// are alive, so that no partition is cured on the early started nodes, and is left lively
+// once the cluster is healthy.
+func StartCluster(cluster string, deploy deployment.Deployment) error {
+	if err := listAndCacheAllNodes(deploy); err != nil {
+		return err
+	}
+
+	if err := startJobNodes(deploy, nodesOfJob(deployment.JobMeta)); err != nil {
+		return err
+	}
+	meta, err := waitPrimaryMeta(cluster)
+	if err != nil {
+		return err
+	}
+	if err := meta.SetMetaLevelBlind(); err != nil {
+		return err
+	}
+
+	replicas := nodesOfJob(deployment.JobReplica)
+	if err := startJobNodes(deploy, replicas); err != nil {
+		return err
+	}
+	if err := waitReplicasAlive(meta, replicas); err != nil {
+		return err
+	}
+
+	if err := meta.SetMetaLevelSteady(); err != nil {
+		return err
+	}
+	log.Print("Wait cluster to become healthy...")
+	if err := waitClusterHealthy(meta, nil); err != nil {
+		return err
+	}
+	log.Print("Cluster becomes healthy")
+	if err := meta.SetMetaLevelLively(); err != nil {
+		return err
+	}
+
+	if err := startJobNodes(deploy, nodesOfJob(deployment.JobCollector)); err != nil {
+		return err
+	}
+	log.Printf("Cluster %s is started", cluster)
+	return nil
+}
+
+func nodesOfJob(job deployment.JobType) []deployment.Node {
+	var nodes []deployment.Node
+	for _, n := range globalAllNodes {
+		if n.Job == job {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func stopJobNodes(deploy deployment.Deployment, nodes []deployment.Node) error {
+	for _, n := range nodes {
+		log.Printf("Stopping %s node %s of %s by deployment...", n.Job, n.Name, n.IPPort)
+		if err := deploy.StopNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startJobNodes(deploy deployment.Deployment, nodes []deployment.Node) error {
+	for _, n := range nodes {
+		log.Printf("Starting %s node %s of %s by deployment...", n.Job, n.Name, n.IPPort)
+		if err := deploy.StartNode(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitPrimaryMeta connects to the MetaServers once a primary is elected.
+func waitPrimaryMeta(cluster string) (metaApi.Meta, error) {
+	log.Print("Waiting for a primary meta to be elected...")
+	var meta metaApi.Meta
+	var lastErr error
+	elected, err := waitFor(func() (bool, error) {
+		if meta == nil {
+			meta, lastErr = connectMeta(cluster)
+			if errors.Is(lastErr, metaApi.ErrClusterNotMatched) {
+				return false, lastErr
+			}
+			if lastErr != nil {
+				return false, nil
+			}
+		}
+		info, err := meta.GetClusterInfo()
+		if err != nil {
+			lastErr = err
+			return false, nil
+		}
+		if info.PrimaryMeta == "" {
+			return false, nil
+		}
+		log.Printf("Primary meta is %s", info.PrimaryMeta)
+		return true, nil
+	}, time.Second, nodeJoinTimeoutSecs)
+	if err != nil {
+		return nil, err
+	}
+	if !elected {
+		return nil, fmt.Errorf("no primary meta is elected in %d seconds, last error: %v", nodeJoinTimeoutSecs, lastErr)
+	}
+	return meta, nil
+}
+
+func waitReplicasAlive(meta metaApi.Meta, replicas []deployment.Node) error {
+	log.Printf("Waiting for %d replica nodes to be alive...", len(replicas))
+	dead := map[string]bool{}
+	allAlive, err := waitFor(func() (bool, error) {
+		infos, err := meta.ListNodes()
+		if err != nil {
+			return false, err
+		}
+		alive := map[string]bool{}
+		for _, info := range infos {
+			if info.Status == admin.NodeStatus_NS_ALIVE {
+				alive[info.Address.GetAddress()] = true
+			}
+		}
+		dead = map[string]bool{}
+		for _, n := range replicas {
+			if !alive[n.IPPort] {
+				dead[n.IPPort] = true
+			}
+		}
+		return len(dead) == 0, nil
+	}, time.Second, nodeJoinTimeoutSecs)
+	if err != nil {
+		return err
+	}
+	if !allAlive {
+		var addrs []string
+		for _, n := range replicas {
+			if dead[n.IPPort] {
+				addrs = append(addrs, n.IPPort)
+			}
+		}
+		return fmt.Errorf("%d replica nodes are not alive in %d seconds, the meta level is left blind: %v",
+			len(addrs), nodeJoinTimeoutSecs, addrs)
+	}
+	return nil
+}
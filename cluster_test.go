@@ -0,0 +1,196 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// newClusterTest returns a cluster of 2 metas, 2 replicas and a collector, the primary
+// meta is the first one.
+func newClusterTest(t *testing.T) (*fakeMeta, *fakeDeployment) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	useFakeMeta(t, m)
+	d := newFakeDeployment(m,
+		deployment.Node{Job: deployment.JobMeta, Name: "m0", IPPort: "127.0.0.1:34601"},
+		deployment.Node{Job: deployment.JobMeta, Name: "m1", IPPort: "127.0.0.1:34602"},
+		replicaNode("1", "127.0.0.1:34801"),
+		replicaNode("2", "127.0.0.1:34802"),
+		deployment.Node{Job: deployment.JobCollector, Name: "c0", IPPort: "127.0.0.1:34101"},
+	)
+	return m, d
+}
+
+func TestStopCluster(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(m *fakeMeta, d *fakeDeployment)
+		// the expected error, empty means success
+		err         string
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name:    "stopped",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {},
+			// the primary meta is the last one
+			deployCalls: []string{"StopNode collector c0", "StopNode replica 1", "StopNode replica 2",
+				"StopNode meta m1", "StopNode meta m0"},
+			metaCalls: []string{"SetMetaLevelBlind"},
+		},
+		{
+			name:    "another cluster",
+			prepare: func(m *fakeMeta, d *fakeDeployment) { m.cluster = "other" },
+			err:     "other",
+		},
+		{
+			name: "primary meta unknown",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["GetClusterInfo"] = errors.New("timeout")
+			},
+			err: "timeout",
+		},
+		{
+			name: "meta fails to become blind",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["SetMetaLevelBlind"] = errors.New("timeout")
+			},
+			err:         "timeout",
+			deployCalls: []string{"StopNode collector c0"},
+			metaCalls:   []string{"SetMetaLevelBlind"},
+		},
+		{
+			name: "replica fails to stop",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.errs["StopNode replica 2"] = errors.New("no permission")
+			},
+			err:         "no permission",
+			deployCalls: []string{"StopNode collector c0", "StopNode replica 1", "StopNode replica 2"},
+			metaCalls:   []string{"SetMetaLevelBlind"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, d := newClusterTest(t)
+			tt.prepare(m, d)
+
+			err := StopCluster("onebox", d)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
+
+func TestStartCluster(t *testing.T) {
+	startMetas := []string{"StartNode meta m0", "StartNode meta m1"}
+	startReplicas := []string{"StartNode meta m0", "StartNode meta m1", "StartNode replica 1", "StartNode replica 2"}
+	tests := []struct {
+		name    string
+		prepare func(m *fakeMeta, d *fakeDeployment)
+		// the expected error, empty means success
+		err         string
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name:    "started",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {},
+			deployCalls: []string{"StartNode meta m0", "StartNode meta m1", "StartNode replica 1", "StartNode replica 2",
+				"StartNode collector c0"},
+			metaCalls: []string{"SetMetaLevelBlind", "SetMetaLevelSteady", "SetMetaLevelLively"},
+		},
+		{
+			name: "meta fails to start",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.errs["StartNode meta m0"] = errors.New("no package")
+			},
+			err:         "no package",
+			deployCalls: []string{"StartNode meta m0"},
+		},
+		{
+			// fails fast instead of waiting for a primary meta
+			name:        "another cluster",
+			prepare:     func(m *fakeMeta, d *fakeDeployment) { m.cluster = "other" },
+			err:         "other",
+			deployCalls: startMetas,
+		},
+		{
+			name: "replica fails to start",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				d.errs["StartNode replica 1"] = errors.New("no package")
+			},
+			err:         "no package",
+			deployCalls: []string{"StartNode meta m0", "StartNode meta m1", "StartNode replica 1"},
+			metaCalls:   []string{"SetMetaLevelBlind"},
+		},
+		{
+			name: "cluster fails to become healthy",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["GetClusterReplicaInfo"] = errors.New("timeout")
+			},
+			err:         "timeout",
+			deployCalls: startReplicas,
+			metaCalls:   []string{"SetMetaLevelBlind", "SetMetaLevelSteady"},
+		},
+		{
+			name: "meta fails to become lively",
+			prepare: func(m *fakeMeta, d *fakeDeployment) {
+				m.errs["SetMetaLevelLively"] = errors.New("timeout")
+			},
+			err:         "timeout",
+			deployCalls: startReplicas,
+			metaCalls:   []string{"SetMetaLevelBlind", "SetMetaLevelSteady", "SetMetaLevelLively"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, d := newClusterTest(t)
+			m.setAlive("127.0.0.1:34801", false)
+			m.setAlive("127.0.0.1:34802", false)
+			tt.prepare(m, d)
+
+			err := StartCluster("onebox", d)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	bootstrapOpts = pegasus.DefaultBootstrapOptions
+
+	bootstrapCmd = &cobra.Command{
+		Use:     "bootstrap",
+		Short:   "Start a new cluster from the nodes in deployment: metas, replicas, then collectors",
+		PreRunE: checkClusterLock,
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, nil, func(deploy deployment.Deployment) error {
+				return pegasus.Bootstrap(cluster, deploy, bootstrapOpts)
+			})
+		},
+	}
+)
+
+func init() {
+	bootstrapCmd.Flags().StringVar(&bootstrapOpts.SmokeTable, "smoke-table", "",
+		"create this table after the cluster is started and wait for it to be healthy, empty means no table is created")
+	bootstrapCmd.Flags().IntVar(&bootstrapOpts.SmokeTablePartitions, "smoke-table-partitions", bootstrapOpts.SmokeTablePartitions,
+		"the partition count of the smoke-test table")
+}
@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/manifoldco/promptui"
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	clusterStopYes bool
+
+	clusterCmd = &cobra.Command{
+		Use:   "cluster",
+		Short: "Stop or start the whole cluster, e.g. for data center maintenance",
+	}
+	clusterStopCmd = &cobra.Command{
+		Use:     "stop",
+		Short:   "Stop all nodes of the cluster: collectors, replicas, backup metas, then the primary meta",
+		PreRunE: checkClusterLock,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !clusterStopYes {
+				prompt := promptui.Prompt{
+					Label:     fmt.Sprintf("Please type 'y' to stop the whole cluster %s", cluster),
+					IsConfirm: true,
+				}
+				if _, err := prompt.Run(); err != nil {
+					fmt.Println("Cancelled")
+					return
+				}
+			}
+			runClusterOp(cmd, nil, func(deploy deployment.Deployment) error {
+				return pegasus.StopCluster(cluster, deploy)
+			})
+		},
+	}
+	clusterStartCmd = &cobra.Command{
+		Use:     "start",
+		Short:   "Start all nodes of the cluster: metas, replicas, then collectors",
+		PreRunE: checkClusterLock,
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, nil, func(deploy deployment.Deployment) error {
+				return pegasus.StartCluster(cluster, deploy)
+			})
+		},
+	}
+)
+
+func init() {
+	clusterStopCmd.Flags().BoolVarP(&clusterStopYes, "yes", "y", false, "stop the cluster without confirmation")
+	clusterCmd.AddCommand(clusterStopCmd, clusterStartCmd)
+}
+
+// checkClusterLock rejects the lock stored in the cluster, which is unreachable while
+// the MetaServers are stopped or not yet started. The file lock is used unless --lock is given.
+func checkClusterLock(cmd *cobra.Command, args []string) error {
+	if lockType != "meta" {
+		return nil
+	}
+	if cmd.Flags().Changed("lock") {
+		return errors.New("--lock=meta can't be used when the cluster is not running, use --lock=file instead")
+	}
+	log.Warnf("the cluster lock is stored in %s since the cluster is not running, which must be shared among operators", lockDir)
+	lockType = "file"
+	return nil
+}
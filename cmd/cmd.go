@@ -21,18 +21,30 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/user"
+	"strings"
 
 	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/audit"
 	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/lock"
 	"github.com/spf13/cobra"
 )
 
 var (
-	all      bool
-	cluster  string
-	metaList string
-	nodes    []string
-	RootCmd  = &cobra.Command{
+	all     bool
+	cluster string
+	nodes   []string
+	limits  = pegasus.DefaultWatchdogLimits
+	retry   = deployment.DefaultRetryPolicy
+
+	auditLogPath string
+
+	scaleOut = pegasus.DefaultScaleOutOptions
+
+	removeAtOnce bool
+
+	RootCmd = &cobra.Command{
 		Use:   "pegasus-cluster-cli",
 		Short: "A command line tool to easily add/remove/update nodes in pegasus cluster",
 	}
@@ -46,11 +58,9 @@ var (
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			deploy := deployment.CreateDeployment(cluster)
-			if err := pegasus.AddNodes(cluster, deploy, metaList, nodes); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+			runClusterOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				return pegasus.AddNodes(cluster, deploy, nodes, scaleOut)
+			})
 		},
 	}
 	removeNodeCmd = &cobra.Command{
@@ -63,11 +73,12 @@ var (
 			return nil
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			deploy := deployment.CreateDeployment(cluster)
-			if err := pegasus.RemoveNodes(cluster, deploy, metaList, nodes); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+			runClusterOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				if removeAtOnce {
+					return pegasus.RemoveNodesAtOnce(cluster, deploy, nodes, limits)
+				}
+				return pegasus.RemoveNodes(cluster, deploy, nodes, limits)
+			})
 		},
 	}
 	rollingUpdateCmd = &cobra.Command{
@@ -79,14 +90,12 @@ var (
 			} else if len(nodes) == 0 {
 				return errors.New("when --all/-a is not specified, a list of nodes(--node/-n) is required")
 			}
-			return nil
+			return checkUpdateOptions()
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			deploy := deployment.CreateDeployment(cluster)
-			if err := pegasus.RollingUpdateNodes(cluster, deploy, metaList, nodes); err != nil {
-				fmt.Println(err)
-				os.Exit(1)
-			}
+			runClusterOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				return pegasus.RollingUpdateNodes(cluster, deploy, nodes, limits)
+			})
 		},
 	}
 )
@@ -95,8 +104,113 @@ func init() {
 	RootCmd.PersistentFlags().StringVarP(&cluster, "cluster", "c", "", "name of the cluster to take action on")
 	RootCmd.PersistentFlags().StringArrayVarP(&nodes, "node", "n", []string{}, "list of nodes to take action on")
 	_ = RootCmd.MarkPersistentFlagRequired("cluster")
+	RootCmd.PersistentFlags().IntVar(&limits.MaxUnhealthyPartitions, "max-unhealthy-partitions", limits.MaxUnhealthyPartitions,
+		"abort when the number of unhealthy partitions exceeds this limit, negative means unlimited")
+	RootCmd.PersistentFlags().IntVar(&limits.MaxDeadNodes, "max-dead-nodes", limits.MaxDeadNodes,
+		"abort when the number of dead nodes other than the operated ones exceeds this limit, negative means unlimited")
+	RootCmd.PersistentFlags().IntVar(&limits.MaxSingleReplicaPartitions, "max-single-replica-partitions", limits.MaxSingleReplicaPartitions,
+		"abort when the number of partitions with a single live replica exceeds this limit, negative means unlimited")
+	RootCmd.PersistentFlags().StringVar(&auditLogPath, "audit-log", audit.DefaultPath(), "the file that records every change made to clusters")
+	RootCmd.PersistentFlags().IntVar(&retry.MaxAttempts, "retry-attempts", retry.MaxAttempts,
+		"the maximum number of attempts of a deployment call that fails transiently, 1 means no retry")
+	RootCmd.PersistentFlags().DurationVar(&retry.InitialBackoff, "retry-backoff", retry.InitialBackoff,
+		"the backoff before the first retry of a deployment call, doubled after each retry")
+	RootCmd.PersistentFlags().DurationVar(&retry.MaxBackoff, "retry-max-backoff", retry.MaxBackoff,
+		"the maximum backoff between retries of a deployment call")
+	RootCmd.PersistentFlags().StringVar(&pegasus.PreflightMode, "preflight", pegasus.PreflightMode,
+		"what to do if cold backup, bulk load or partition split is running before taking nodes offline. Options: block|warn|skip")
+	RootCmd.PersistentFlags().StringVar(&pegasus.StateTable, "state-table", pegasus.StateTable,
+		"the table whose app envs keep the cluster states shared among operators, like the drained nodes")
+	addNodeCmd.Flags().DurationVar(&scaleOut.JoinTimeout, "join-timeout", scaleOut.JoinTimeout,
+		"how long to wait for the started nodes to be alive in meta, rebalance is skipped if any node doesn't join")
+	addNodeCmd.Flags().IntVar(&scaleOut.BatchSize, "batch-size", scaleOut.BatchSize,
+		"the number of nodes added in a wave, each wave is followed by a rebalance, 0 means all nodes in one wave")
+	addNodeCmd.Flags().IntVar(&scaleOut.MaxAddSecondaryPerNode, "max-add-secondary-per-node", scaleOut.MaxAddSecondaryPerNode,
+		"the maximum number of secondaries added to a node at a time during rebalance, 0 means unlimited")
+	addNodeCmd.Flags().IntVar(&scaleOut.CopyRateMB, "copy-rate-mb", scaleOut.CopyRateMB,
+		"the maximum rate in MB/s of a node copying data during rebalance, 0 means unlimited")
+	removeNodeCmd.Flags().BoolVar(&removeAtOnce, "all-at-once", false,
+		"migrate the replicas on all nodes onto the surviving nodes in one pass, then stop all nodes")
 	rollingUpdateCmd.Flags().BoolVarP(&all, "all", "a", false, "whether to update all nodes")
-	RootCmd.AddCommand(addNodeCmd, removeNodeCmd, rollingUpdateCmd)
+	rollingUpdateCmd.Flags().StringVar(&minosUpdate.PackageRevision, "package-revision", "",
+		"the package revision to deploy, the latest one if not specified. Only supported by minos")
+	rollingUpdateCmd.Flags().BoolVar(&minosUpdate.SkipPackage, "config-only", false,
+		"only update the config, keep the package unchanged. Only supported by minos")
+	rollingUpdateCmd.Flags().BoolVar(&minosUpdate.SkipConfig, "package-only", false,
+		"only update the package, keep the config unchanged. Only supported by minos")
+	RootCmd.AddCommand(addNodeCmd, removeNodeCmd, rollingUpdateCmd, replaceNodeCmd, restartNodeCmd,
+		drainNodeCmd, undrainNodeCmd, rebalanceCmd, historyCmd, lockCmd, clusterCmd, bootstrapCmd, execCmd)
+}
+
+// runClusterOp runs an operation that changes the cluster, and records it into the audit log.
+// The process exits with non-zero code if the operation fails.
+func runClusterOp(cmd *cobra.Command, opNodes []string, op func(deploy deployment.Deployment) error) {
+	runAuditedOp(cmd, opNodes, true, op)
+}
+
+// runUnlockedOp is like runClusterOp, but doesn't hold the cluster lock, for the operations
+// that don't change the membership or placement of the cluster, so they can run alongside
+// others.
+func runUnlockedOp(cmd *cobra.Command, opNodes []string, op func(deploy deployment.Deployment) error) {
+	runAuditedOp(cmd, opNodes, false, op)
+}
+
+func runAuditedOp(cmd *cobra.Command, opNodes []string, locking bool, op func(deploy deployment.Deployment) error) {
+	// subcommands are recorded with their parents, e.g. "cluster stop"
+	command := strings.TrimPrefix(cmd.CommandPath(), RootCmd.Name()+" ")
+	auditLog, err := audit.Begin(auditLogPath, currentOperator(), cluster, command, opNodes)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	pegasus.SetAuditLog(auditLog)
+
+	var holder *lock.Holder
+	deploy, err := newDeployment(cluster)
+	if err == nil {
+		deploy = audit.WrapDeployment(deployment.WithRetry(deploy, retry), auditLog)
+		if locking {
+			holder, err = holdLock(cmd, deploy)
+		}
+	}
+	if err == nil {
+		err = runHoldingLock(holder, func() error { return op(deploy) })
+		if holder != nil {
+			if releaseErr := holder.Release(); releaseErr != nil {
+				fmt.Printf("failed to release the cluster lock: %s\n", releaseErr)
+			}
+		}
+	}
+	auditLog.End(err)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// runHoldingLock runs op, and aborts it once the cluster lock is lost, since others may
+// be operating the cluster.
+func runHoldingLock(holder *lock.Holder, op func() error) error {
+	if holder == nil {
+		return op()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-holder.Lost():
+		return fmt.Errorf("aborted since %s, the cluster may be left in the middle of the operation", holder.Err())
+	}
+}
+
+func currentOperator() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
 }
 
 func Execute() error {
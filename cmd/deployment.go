@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/deployment/httpapi"
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
+	"github.com/pegasus-kv/cluster-cli/deployment/onebox"
+	"github.com/pegasus-kv/cluster-cli/deployment/plugin"
+)
+
+var (
+	deploymentName   string
+	deploymentConfig string
+	deploymentPlugin string
+
+	// what rolling-update deploys, only supported by minos
+	minosUpdate minos.UpdateOptions
+)
+
+func init() {
+	RootCmd.PersistentFlags().StringVar(&deploymentName, "deployment", "minos",
+		"the deployment system that operates the nodes. Options: minos|httpapi|plugin|onebox")
+	RootCmd.PersistentFlags().StringVar(&deploymentConfig, "deployment-config", "",
+		"the config file of the deployment system, required by httpapi and onebox, passed to the plugin as its argument")
+	RootCmd.PersistentFlags().StringVar(&deploymentPlugin, "deployment-plugin", "",
+		"the executable that acts as the deployment system, required by plugin")
+}
+
+// newDeployment creates the Deployment of the cluster. deployment.CreateDeployment takes
+// precedence if a custom build of pegasus-cluster-cli sets it.
+func newDeployment(cluster string) (deployment.Deployment, error) {
+	if deployment.CreateDeployment != nil {
+		return deployment.CreateDeployment(cluster), nil
+	}
+	switch deploymentName {
+	case "minos":
+		m, err := minos.NewMinos(cluster, currentOperator())
+		if err != nil {
+			return nil, err
+		}
+		m.SetUpdateOptions(minosUpdate)
+		return m, nil
+	case "httpapi":
+		if deploymentConfig == "" {
+			return nil, errors.New("--deployment-config is required by httpapi")
+		}
+		cfg, err := httpapi.LoadConfig(deploymentConfig)
+		if err != nil {
+			return nil, err
+		}
+		return httpapi.New(cluster, cfg)
+	case "onebox":
+		if deploymentConfig == "" {
+			return nil, errors.New("--deployment-config is required by onebox")
+		}
+		cfg, err := onebox.LoadConfig(deploymentConfig)
+		if err != nil {
+			return nil, err
+		}
+		return onebox.New(cluster, cfg)
+	case "plugin":
+		if deploymentPlugin == "" {
+			return nil, errors.New("--deployment-plugin is required by plugin")
+		}
+		var args []string
+		if deploymentConfig != "" {
+			args = append(args, deploymentConfig)
+		}
+		return plugin.New(cluster, deploymentPlugin, args, plugin.DefaultTimeout)
+	default:
+		return nil, fmt.Errorf("unrecognized deployment \"%s\"", deploymentName)
+	}
+}
+
+// checkUpdateOptions validates the rolling-update flags that select what to deploy.
+func checkUpdateOptions() error {
+	if minosUpdate == (minos.UpdateOptions{}) {
+		return nil
+	}
+	if deploymentName != "minos" || deployment.CreateDeployment != nil {
+		return errors.New("--package-revision, --config-only and --package-only are only supported by minos")
+	}
+	if minosUpdate.SkipPackage && minosUpdate.SkipConfig {
+		return errors.New("--config-only and --package-only are exclusive")
+	}
+	if minosUpdate.SkipPackage && minosUpdate.PackageRevision != "" {
+		return errors.New("--package-revision can't be used with --config-only")
+	}
+	return nil
+}
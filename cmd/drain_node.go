@@ -0,0 +1,59 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"errors"
+
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	drainNodeCmd = &cobra.Command{
+		Use:   "drain-node",
+		Short: "Move all replicas off a list of replica nodes for maintenance, without stopping them",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(nodes) == 0 {
+				return errors.New("list of nodes must be provided")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				return pegasus.DrainNodes(cluster, deploy, nodes, limits)
+			})
+		},
+	}
+	undrainNodeCmd = &cobra.Command{
+		Use:   "undrain-node",
+		Short: "Let a list of drained replica nodes serve again",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if len(nodes) == 0 {
+				return errors.New("list of nodes must be provided")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				return pegasus.UndrainNodes(cluster, deploy, nodes)
+			})
+		},
+	}
+)
@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pegasus-kv/admin-cli/tabular"
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	execOpts   = pegasus.ExecOptions{Concurrency: 10}
+	execOutput string
+
+	execCmd = &cobra.Command{
+		Use:   "exec <remote command> [args...]",
+		Short: "Run a remote command, like server-info or flush-log, on the selected nodes",
+		Example: "  pegasus-cluster-cli exec -c onebox --job replica server-info\n" +
+			"  pegasus-cluster-cli exec -c onebox --node replica1 --node replica2 flush-log",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkExecFlags,
+		Run: func(cmd *cobra.Command, args []string) {
+			// the remote commands are audited, but not serialized with the operations changing the cluster
+			runUnlockedOp(cmd, nodes, func(deploy deployment.Deployment) error {
+				return runExec(deploy, args)
+			})
+		},
+	}
+)
+
+func init() {
+	execCmd.Flags().StringVar(&execOpts.Job, "job", "", "only run on the nodes of this job. Options: meta|replica|collector")
+	execCmd.Flags().StringToStringVar(&execOpts.Selector, "selector", nil,
+		"only run on the nodes with these attributes reported by the deployment, like Status=Running")
+	execCmd.Flags().IntVar(&execOpts.Concurrency, "concurrency", execOpts.Concurrency,
+		"the maximum number of nodes that the command is running on at the same time")
+	execCmd.Flags().StringVarP(&execOutput, "output", "o", "table", "the format of results. Options: table|json")
+}
+
+type execRow struct {
+	Node   string `json:"node"`
+	Job    string `json:"job"`
+	IPPort string `json:"ip_port"`
+	Output string `json:"output"`
+	Error  string `json:"error"`
+}
+
+func checkExecFlags(cmd *cobra.Command, args []string) error {
+	if execOpts.Job != "" {
+		if _, err := deployment.ParseJobType(execOpts.Job); err != nil {
+			return err
+		}
+	}
+	if execOutput != "table" && execOutput != "json" {
+		return fmt.Errorf("unrecognized output format \"%s\"", execOutput)
+	}
+	return nil
+}
+
+// runExec runs the remote command and prints the results, it fails if the command fails on any node.
+func runExec(deploy deployment.Deployment, args []string) error {
+	execOpts.Nodes = nodes
+	results, err := pegasus.Exec(deploy, args[0], args[1:], execOpts)
+	if err != nil {
+		return err
+	}
+	return printExecResults(os.Stdout, results, execOutput)
+}
+
+// printExecResults prints the results in the format, it fails if the command failed on any node.
+func printExecResults(w io.Writer, results []pegasus.ExecResult, format string) error {
+	var rows []interface{}
+	failed := 0
+	for _, r := range results {
+		row := execRow{
+			Node:   r.Node.Name,
+			Job:    r.Node.Job.String(),
+			IPPort: r.Node.IPPort,
+			Output: strings.TrimSpace(r.Output),
+		}
+		if r.Err != nil {
+			row.Error = r.Err.Error()
+			failed++
+		}
+		rows = append(rows, row)
+	}
+	if format == "json" {
+		data, err := json.MarshalIndent(rows, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, string(data))
+	} else {
+		tabular.Print(w, rows)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("command failed on %d of %d nodes", failed, len(results))
+	}
+	return nil
+}
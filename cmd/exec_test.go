@@ -0,0 +1,62 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+var execResults = []pegasus.ExecResult{
+	{Node: deployment.Node{Job: deployment.JobReplica, Name: "1", IPPort: "127.0.0.1:34801"}, Output: "OK\n"},
+	{Node: deployment.Node{Job: deployment.JobReplica, Name: "2", IPPort: "127.0.0.1:34802"},
+		Err: errors.New("connection refused")},
+}
+
+func TestPrintExecResults(t *testing.T) {
+	var buf bytes.Buffer
+	err := printExecResults(&buf, execResults, "json")
+	if err == nil || err.Error() != "command failed on 1 of 2 nodes" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var rows []execRow
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	expected := []execRow{
+		{Node: "1", Job: "replica", IPPort: "127.0.0.1:34801", Output: "OK"},
+		{Node: "2", Job: "replica", IPPort: "127.0.0.1:34802", Error: "connection refused"},
+	}
+	if len(rows) != len(expected) || rows[0] != expected[0] || rows[1] != expected[1] {
+		t.Errorf("unexpected rows %+v", rows)
+	}
+
+	buf.Reset()
+	if err := printExecResults(&buf, execResults[:1], "table"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "| 1    | replica | 127.0.0.1:34801 | OK     |       |") {
+		t.Errorf("unexpected table\n%s", buf.String())
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pegasus-kv/admin-cli/tabular"
+	"github.com/pegasus-kv/cluster-cli/audit"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFilter audit.Filter
+	historySince  time.Duration
+	historyLimit  int
+	historyOpID   string
+
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "List the past operations on the cluster from the audit log",
+		RunE:  runHistory,
+	}
+)
+
+func init() {
+	historyCmd.Flags().StringVar(&historyFilter.Operator, "operator", "", "only show the operations by this operator")
+	historyCmd.Flags().StringVar(&historyFilter.Command, "command", "", "only show the operations of this command, like rolling-update")
+	historyCmd.Flags().StringVar(&historyFilter.Outcome, "outcome", "", "only show the operations with this outcome. Options: success|failed|unfinished")
+	historyCmd.Flags().DurationVar(&historySince, "since", 0, "only show the operations began within this duration, like 72h")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 20, "show at most this number of the latest operations, 0 means unlimited")
+	historyCmd.Flags().StringVar(&historyOpID, "op", "", "show every action of the operation with this ID")
+}
+
+type historyRow struct {
+	OpID     string `json:"op_id"`
+	Begin    string `json:"begin"`
+	Duration string `json:"duration"`
+	Operator string `json:"operator"`
+	Command  string `json:"command"`
+	Nodes    string `json:"nodes"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error"`
+}
+
+type historyActionRow struct {
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	Action  string `json:"action"`
+	Args    string `json:"args"`
+	Success bool   `json:"success"`
+	Elapsed string `json:"elapsed"`
+	Error   string `json:"error"`
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	records, err := audit.ReadRecords(auditLogPath)
+	if err != nil {
+		return err
+	}
+	ops := audit.ListOperations(records)
+
+	if historyOpID != "" {
+		for _, op := range ops {
+			if op.OpID == historyOpID {
+				printOperationActions(cmd.OutOrStdout(), op)
+				return nil
+			}
+		}
+		return fmt.Errorf("operation \"%s\" was not found in %s", historyOpID, auditLogPath)
+	}
+
+	historyFilter.Cluster = cluster
+	if historySince != 0 {
+		historyFilter.Since = time.Now().Add(-historySince)
+	}
+	var matched []*audit.Operation
+	for _, op := range ops {
+		if historyFilter.Match(op) {
+			matched = append(matched, op)
+		}
+	}
+	if historyLimit > 0 && len(matched) > historyLimit {
+		matched = matched[len(matched)-historyLimit:]
+	}
+
+	var rows []interface{}
+	for _, op := range matched {
+		duration := ""
+		if !op.End.IsZero() {
+			duration = op.End.Sub(op.Begin).Round(time.Second).String()
+		}
+		rows = append(rows, historyRow{
+			OpID:     op.OpID,
+			Begin:    op.Begin.Format(time.RFC3339),
+			Duration: duration,
+			Operator: op.Operator,
+			Command:  op.Command,
+			Nodes:    strings.Join(op.Nodes, ","),
+			Outcome:  op.Outcome,
+			Error:    op.Error,
+		})
+	}
+	tabular.Print(cmd.OutOrStdout(), rows)
+	return nil
+}
+
+func printOperationActions(w io.Writer, op *audit.Operation) {
+	var rows []interface{}
+	for _, r := range op.Records {
+		rows = append(rows, historyActionRow{
+			Time:    r.Time.Format(time.RFC3339),
+			Type:    r.Type,
+			Action:  r.Action,
+			Args:    strings.Join(r.Args, " "),
+			Success: r.Success,
+			Elapsed: (time.Duration(r.ElapsedMs) * time.Millisecond).String(),
+			Error:   r.Error,
+		})
+	}
+	tabular.Print(w, rows)
+}
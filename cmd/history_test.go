@@ -0,0 +1,148 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/audit"
+)
+
+// writeAuditLog writes the records of the operations into a temporary audit log:
+// a succeeded rolling-update, a failed remove-node, and an unfinished add-node of onebox,
+// and a rebalance of another cluster.
+func writeAuditLog(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cluster-cli-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "audit.log")
+
+	begin := time.Now().Add(-time.Hour)
+	records := []audit.Record{
+		{OpID: "op-update", Operator: "alice", Cluster: "onebox", Command: "rolling-update", Type: audit.TypeBegin, Time: begin},
+		{OpID: "op-update", Operator: "alice", Cluster: "onebox", Command: "rolling-update", Type: audit.TypeMeta,
+			Action: "meta_level", Args: []string{"steady"}, Success: true, Time: begin},
+		{OpID: "op-update", Operator: "alice", Cluster: "onebox", Command: "rolling-update", Type: audit.TypeEnd,
+			Success: true, Time: begin, ElapsedMs: 60000},
+		{OpID: "op-remove", Operator: "bob", Cluster: "onebox", Command: "remove-node", Nodes: []string{"3"},
+			Type: audit.TypeBegin, Time: begin.Add(time.Minute)},
+		{OpID: "op-remove", Operator: "bob", Cluster: "onebox", Command: "remove-node", Nodes: []string{"3"},
+			Type: audit.TypeEnd, Error: "node 3 is not found", Time: begin.Add(time.Minute)},
+		{OpID: "op-add", Operator: "bob", Cluster: "onebox", Command: "add-node", Type: audit.TypeBegin,
+			Time: begin.Add(2 * time.Minute)},
+		{OpID: "op-other", Operator: "alice", Cluster: "other", Command: "rebalance", Type: audit.TypeBegin,
+			Time: begin.Add(3 * time.Minute)},
+	}
+	var buf bytes.Buffer
+	for _, r := range records {
+		line, err := json.Marshal(&r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// runHistoryCmd executes the history command with the flags reset, and returns its output.
+func runHistoryCmd(t *testing.T, args ...string) (string, error) {
+	historyFilter = audit.Filter{}
+	historySince = 0
+	historyLimit = 20
+	historyOpID = ""
+
+	var out bytes.Buffer
+	RootCmd.SetOut(&out)
+	RootCmd.SetErr(ioutil.Discard)
+	t.Cleanup(func() {
+		RootCmd.SetOut(nil)
+		RootCmd.SetErr(nil)
+	})
+	RootCmd.SetArgs(append([]string{"history"}, args...))
+	err := RootCmd.Execute()
+	return out.String(), err
+}
+
+func TestHistory(t *testing.T) {
+	path := writeAuditLog(t)
+	tests := []struct {
+		name string
+		args []string
+		// the operations expected in the output
+		ops []string
+	}{
+		{name: "all of the cluster", args: nil, ops: []string{"op-update", "op-remove", "op-add"}},
+		{name: "another cluster", args: []string{"-c", "other"}, ops: []string{"op-other"}},
+		{name: "by operator", args: []string{"--operator", "bob"}, ops: []string{"op-remove", "op-add"}},
+		{name: "by command", args: []string{"--command", "remove-node"}, ops: []string{"op-remove"}},
+		{name: "succeeded", args: []string{"--outcome", audit.OutcomeSuccess}, ops: []string{"op-update"}},
+		{name: "failed", args: []string{"--outcome", audit.OutcomeFailed}, ops: []string{"op-remove"}},
+		{name: "unfinished", args: []string{"--outcome", audit.OutcomeUnfinished}, ops: []string{"op-add"}},
+		{name: "since", args: []string{"--since", "59m30s"}, ops: []string{"op-remove", "op-add"}},
+		{name: "latest", args: []string{"--limit", "2"}, ops: []string{"op-remove", "op-add"}},
+	}
+	allOps := []string{"op-update", "op-remove", "op-add", "op-other"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"-c", "onebox", "--audit-log", path}, tt.args...)
+			out, err := runHistoryCmd(t, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, op := range allOps {
+				expected := false
+				for _, o := range tt.ops {
+					expected = expected || o == op
+				}
+				if strings.Contains(out, op) != expected {
+					t.Errorf("expect %s listed: %v, got output:\n%s", op, expected, out)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryOperation(t *testing.T) {
+	path := writeAuditLog(t)
+
+	out, err := runHistoryCmd(t, "-c", "onebox", "--audit-log", path, "--op", "op-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"begin", "meta_level", "steady", "end"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expect %s in the actions:\n%s", s, out)
+		}
+	}
+
+	if _, err := runHistoryCmd(t, "-c", "onebox", "--audit-log", path, "--op", "op-unknown"); err == nil {
+		t.Error("expect error for an unknown operation")
+	}
+}
@@ -0,0 +1,159 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/manifoldco/promptui"
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/audit"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/lock"
+	"github.com/spf13/cobra"
+)
+
+var (
+	lockType string
+	lockDir  string
+	lockTTL  time.Duration
+
+	lockCmd = &cobra.Command{
+		Use:   "lock",
+		Short: "Manage the lock that prevents concurrent operations on the cluster",
+	}
+	lockStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show who is holding the lock of the cluster",
+		RunE:  runLockStatus,
+	}
+	lockBreakCmd = &cobra.Command{
+		Use:   "break",
+		Short: "Forcibly release the lock of the cluster",
+		RunE:  runLockBreak,
+	}
+)
+
+func init() {
+	defaultLockDir := filepath.Join(filepath.Dir(audit.DefaultPath()), "locks")
+	RootCmd.PersistentFlags().StringVar(&lockType, "lock", "meta",
+		"where the cluster lock is stored, meta means the app envs of --state-table. Options: meta|file|none")
+	RootCmd.PersistentFlags().StringVar(&lockDir, "lock-dir", defaultLockDir,
+		"the directory of lock files, used with --lock=file. It must be shared among operators, like on NFS, "+
+			"otherwise the lock only excludes the operations of the same user")
+	RootCmd.PersistentFlags().DurationVar(&lockTTL, "lock-ttl", 30*time.Minute,
+		"the lock expires if it's not refreshed within this duration, e.g. the holder crashed")
+	lockCmd.AddCommand(lockStatusCmd, lockBreakCmd)
+}
+
+// newLock returns the lock of the cluster, or nil if locking is disabled.
+func newLock(deploy deployment.Deployment) (lock.Lock, error) {
+	switch lockType {
+	case "file":
+		return lock.NewFileLock(lockDir, cluster), nil
+	case "meta":
+		m, err := pegasus.NewMeta(cluster, deploy)
+		if err != nil {
+			return nil, err
+		}
+		return lock.NewMetaLock(m, pegasus.StateTable), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unrecognized type of lock \"%s\"", lockType)
+	}
+}
+
+// holdLock takes the cluster lock for the command. The returned holder is nil if locking is disabled.
+func holdLock(cmd *cobra.Command, deploy deployment.Deployment) (*lock.Holder, error) {
+	l, err := newLock(deploy)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	return lock.Hold(l, lock.NewInfo(cluster, currentOperator(), cmd.CommandPath(), lockTTL))
+}
+
+func runLockStatus(cmd *cobra.Command, args []string) error {
+	deploy, err := newDeployment(cluster)
+	if err != nil {
+		return err
+	}
+	l, err := newLock(deploy)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		fmt.Println("Locking is disabled")
+		return nil
+	}
+	holder, err := l.Status()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		fmt.Printf("Cluster %s is not locked\n", cluster)
+		return nil
+	}
+	if holder.Expired() {
+		fmt.Printf("Cluster %s was locked but expired: %s\n", cluster, holder)
+		return nil
+	}
+	fmt.Printf("Cluster %s is locked: %s\n", cluster, holder)
+	return nil
+}
+
+func runLockBreak(cmd *cobra.Command, args []string) error {
+	deploy, err := newDeployment(cluster)
+	if err != nil {
+		return err
+	}
+	l, err := newLock(deploy)
+	if err != nil {
+		return err
+	}
+	if l == nil {
+		fmt.Println("Locking is disabled")
+		return nil
+	}
+	holder, err := l.Status()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		fmt.Printf("Cluster %s is not locked\n", cluster)
+		return nil
+	}
+	fmt.Printf("Cluster %s is locked: %s\n", cluster, holder)
+
+	// Require confirmation to proceed, since the holder may still be operating.
+	prompt := promptui.Prompt{
+		Label:     "Please type 'y' to break the lock",
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		fmt.Println("Cancelled")
+		return nil
+	}
+	if err := l.Break(); err != nil {
+		return err
+	}
+	fmt.Printf("Lock of cluster %s is broken\n", cluster)
+	return nil
+}
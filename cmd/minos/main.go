@@ -18,11 +18,19 @@
 package main
 
 import (
+	"os"
+
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
+	"github.com/pegasus-kv/cluster-cli/meta"
 	"github.com/spf13/cobra"
 )
 
 var (
 	rootCmd *cobra.Command
+
+	// the clients of minos and meta, changeable in tests
+	newMinos      = minos.NewMinos
+	newMetaClient = meta.NewMetaClient
 )
 
 func init() {
@@ -30,9 +38,11 @@ func init() {
 		Use:   "minos",
 		Short: "Minos CLI that can operates on Pegasus nodes.",
 	}
+	rootCmd.AddCommand(showCmd, startCmd, stopCmd, rollingUpdateCmd)
 }
 
 func main() {
-	rootCmd.AddCommand(showCmd, startCmd, stopCmd, rollingUpdateCmd)
-	_ = rootCmd.Execute()
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
 }
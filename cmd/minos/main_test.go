@@ -0,0 +1,106 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// fakeMinos records the batch operations. The other methods panic through the embedded
+// nil interface.
+type fakeMinos struct {
+	minos.Minos
+
+	nodes []deployment.Node
+	// the batch operations, like "start replica [1 2]"
+	calls []string
+	opts  minos.BatchOptions
+}
+
+func (f *fakeMinos) ListAllNodes() ([]deployment.Node, error) {
+	return f.nodes, nil
+}
+
+func (f *fakeMinos) BatchOp(action string, job deployment.JobType, taskIDs []int, opts minos.BatchOptions) error {
+	f.calls = append(f.calls, fmt.Sprintf("%s %s %v", action, job, taskIDs))
+	f.opts = opts
+	return nil
+}
+
+// useFakeMinos makes the commands operate a fake minos with a meta and 3 replica nodes.
+func useFakeMinos(t *testing.T) *fakeMinos {
+	meta := deployment.NewNode("1", "127.0.0.1:34601", deployment.JobMeta)
+	meta.Attrs[minos.AttrPackageRevision] = "pkg-meta"
+	f := &fakeMinos{nodes: []deployment.Node{meta}}
+	for _, id := range []string{"3", "1", "2"} {
+		n := deployment.NewNode(id, "127.0.0.1:3480"+id, deployment.JobReplica)
+		n.Attrs[minos.AttrPackageRevision] = "pkg-" + id
+		n.Attrs[minos.AttrConfigRevision] = "conf-" + id
+		f.nodes = append(f.nodes, n)
+	}
+
+	old := newMinos
+	newMinos = func(cluster string, userName string) (minos.Minos, error) {
+		return f, nil
+	}
+	t.Cleanup(func() { newMinos = old })
+	return f
+}
+
+// runCommand executes the command line with the flags reset, and returns the output.
+// The confirmation is answered with `answer` if asked.
+func runCommand(t *testing.T, answer bool, args ...string) (out string, asked bool, err error) {
+	for _, cmd := range rootCmd.Commands() {
+		resetFlags(cmd)
+	}
+	oldConfirm := confirm
+	confirm = func() bool {
+		asked = true
+		return answer
+	}
+	var buf bytes.Buffer
+	rootCmd.SetOut(&buf)
+	rootCmd.SetErr(ioutil.Discard)
+	defer func() {
+		confirm = oldConfirm
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+	}()
+	rootCmd.SetArgs(args)
+	err = rootCmd.Execute()
+	return buf.String(), asked, err
+}
+
+func resetFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			_ = s.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+}
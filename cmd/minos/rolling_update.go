@@ -1,25 +1,38 @@
 package main
 
 import (
-	"github.com/pegasus-kv/cluster-cli/deployment"
+	"errors"
+
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
 	"github.com/spf13/cobra"
 )
 
 var (
 	rollingUpdateCmd = &cobra.Command{
-		Use:   "rolling-update <cluster_name> --task {TaskID} --job {replica|meta|collector} --user {user_name}",
+		Use:   "rolling-update <cluster_name> {--task {TaskID}... | --all} --job {replica|meta|collector} [--package-revision {Revision} | --config-only | --package-only] [--yes]",
 		Args:  cobra.ExactArgs(1),
-		Short: "Rolling-update a single Pegasus node",
+		Short: "Rolling-update Pegasus nodes",
 		RunE:  runRollingUpdate,
 	}
 )
 
 func init() {
 	registerOpCmdFlags(rollingUpdateCmd)
+	rollingUpdateCmd.Flags().String("package-revision", "", "The package revision to deploy, the latest one if not specified.")
+	rollingUpdateCmd.Flags().Bool("config-only", false, "Only update the config, keep the package unchanged.")
+	rollingUpdateCmd.Flags().Bool("package-only", false, "Only update the package, keep the config unchanged.")
 }
 
 func runRollingUpdate(cmd *cobra.Command, args []string) error {
-	return runNodeOp(cmd, args, func(m deployment.Deployment, node deployment.Node) error {
-		return m.RollingUpdate(node)
-	})
+	var update minos.UpdateOptions
+	update.PackageRevision, _ = cmd.Flags().GetString("package-revision")
+	update.SkipPackage, _ = cmd.Flags().GetBool("config-only")
+	update.SkipConfig, _ = cmd.Flags().GetBool("package-only")
+	if update.SkipPackage && update.SkipConfig {
+		return errors.New("--config-only and --package-only are exclusive")
+	}
+	if update.SkipPackage && update.PackageRevision != "" {
+		return errors.New("--package-revision can't be used with --config-only")
+	}
+	return runNodeOp(cmd, args, "rolling_update", update)
 }
@@ -0,0 +1,84 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
+)
+
+func TestRollingUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// the expected error, empty means success
+		err    string
+		update minos.UpdateOptions
+	}{
+		{name: "latest"},
+		{name: "package revision", args: []string{"--package-revision", "r2"},
+			update: minos.UpdateOptions{PackageRevision: "r2"}},
+		{name: "config only", args: []string{"--config-only"}, update: minos.UpdateOptions{SkipPackage: true}},
+		{name: "package only", args: []string{"--package-only", "--package-revision", "r2"},
+			update: minos.UpdateOptions{SkipConfig: true, PackageRevision: "r2"}},
+		{name: "nothing to update", args: []string{"--config-only", "--package-only"}, err: "exclusive"},
+		{name: "package revision of config only", args: []string{"--config-only", "--package-revision", "r2"},
+			err: "--package-revision can't be used with --config-only"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeMinos(t)
+			args := append([]string{"rolling-update", "onebox", "--job", "replica", "--task", "2", "--yes"}, tt.args...)
+			_, _, err := runCommand(t, false, args...)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(f.calls) != 0 {
+					t.Errorf("unexpected operations %v", f.calls)
+				}
+				return
+			}
+			if strings.Join(f.calls, ",") != "rolling_update replica [2]" {
+				t.Errorf("unexpected operations %v", f.calls)
+			}
+			if f.opts != (minos.BatchOptions{Concurrency: 1, UpdateOptions: tt.update}) {
+				t.Errorf("unexpected options %+v", f.opts)
+			}
+		})
+	}
+}
+
+func TestRollingUpdateConfirmation(t *testing.T) {
+	f := useFakeMinos(t)
+	out, asked, err := runCommand(t, false, "rolling-update", "onebox", "--job", "replica", "--all", "--package-revision", "r2")
+	if !asked || err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expect the operation cancelled, got %v", err)
+	}
+	if !strings.Contains(out, "rolling-update onebox replica [3 1 2]") || !strings.Contains(out, "package revision: r2") {
+		t.Errorf("the operation should be shown before confirmation: %s", out)
+	}
+	if len(f.calls) != 0 {
+		t.Errorf("unexpected operations %v", f.calls)
+	}
+}
@@ -18,36 +18,50 @@
 package main
 
 import (
-	"os"
+	"fmt"
+	"io"
 	"sort"
 	"strconv"
 
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/pegasus-kv/admin-cli/tabular"
 	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
 	"github.com/spf13/cobra"
 )
 
 var (
 	showCmd = &cobra.Command{
-		Use:   "show <cluster_name>",
+		Use:   "show <cluster_name> [--meta]",
 		Args:  cobra.ExactArgs(1),
 		Short: "Show the status of Pegasus nodes",
 		RunE:  runShow,
 	}
 )
 
+func init() {
+	showCmd.Flags().Bool("meta", false, "Also show the node status reported by the MetaServers of the cluster.")
+}
+
 func runShow(cmd *cobra.Command, args []string) error {
 	cluster := args[0]
 
 	// user name is not required for `show`
-	m := deployment.NewMinos(cluster, "")
-	return printAllNodes(m)
+	m, err := newMinos(cluster, "")
+	if err != nil {
+		return err
+	}
+	withMeta, _ := cmd.Flags().GetBool("meta")
+	if withMeta {
+		return printAllNodesWithMeta(cmd.OutOrStdout(), cluster, m)
+	}
+	return printAllNodes(cmd.OutOrStdout(), m)
 }
 
-func printAllNodes(m deployment.Deployment) error {
+func listSortedNodes(m deployment.Deployment) ([]deployment.Node, error) {
 	nodes, err := m.ListAllNodes()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	sort.Slice(nodes, func(i, j int) bool {
@@ -58,11 +72,114 @@ func printAllNodes(m deployment.Deployment) error {
 		}
 		return nodes[i].Job < nodes[j].Job
 	})
+	return nodes, nil
+}
+
+func printAllNodes(w io.Writer, m deployment.Deployment) error {
+	nodes, err := listSortedNodes(m)
+	if err != nil {
+		return err
+	}
 
 	var rows []interface{}
 	for _, n := range nodes {
-		rows = append(rows, n)
+		rows = append(rows, nodeRow{
+			Job:             n.Job.String(),
+			Name:            n.Name,
+			IPPort:          n.IPPort,
+			Hostname:        n.Hostname,
+			PackageRevision: attrString(n, minos.AttrPackageRevision),
+			ConfigRevision:  attrString(n, minos.AttrConfigRevision),
+		})
+	}
+	tabular.Print(w, rows)
+	return nil
+}
+
+// nodeRow is a node in the deployment along with its deployed revisions.
+type nodeRow struct {
+	Job             string `json:"job"`
+	Name            string `json:"name"`
+	IPPort          string `json:"ip_port"`
+	Hostname        string `json:"hostname"`
+	PackageRevision string `json:"package_revision"`
+	ConfigRevision  string `json:"config_revision"`
+}
+
+func attrString(n deployment.Node, key string) string {
+	if v, ok := n.Attrs[key]; ok {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+// nodeWithMetaRow is a node in the deployment joined with its status in MetaServer.
+type nodeWithMetaRow struct {
+	Job         string `json:"job"`
+	Name        string `json:"name"`
+	IPPort      string `json:"ip_port"`
+	Hostname    string `json:"hostname"`
+	Package     string `json:"package_revision"`
+	Config      string `json:"config_revision"`
+	Status      string `json:"status"`
+	Primaries   string `json:"primary_count"`
+	Replicas    string `json:"replica_count"`
+	PrimaryMeta string `json:"primary_meta"`
+}
+
+func printAllNodesWithMeta(w io.Writer, cluster string, m deployment.Deployment) error {
+	nodes, err := listSortedNodes(m)
+	if err != nil {
+		return err
+	}
+	var metaList []string
+	for _, n := range nodes {
+		if n.Job == deployment.JobMeta {
+			metaList = append(metaList, n.IPPort)
+		}
+	}
+	metaClient, err := newMetaClient(cluster, metaList)
+	if err != nil {
+		return err
+	}
+	info, err := metaClient.GetClusterInfo()
+	if err != nil {
+		return err
+	}
+	replicaInfo, err := metaClient.GetClusterReplicaInfo()
+	if err != nil {
+		return err
+	}
+
+	var rows []interface{}
+	for _, n := range nodes {
+		row := nodeWithMetaRow{
+			Job:      n.Job.String(),
+			Name:     n.Name,
+			IPPort:   n.IPPort,
+			Hostname: n.Hostname,
+			Package:  attrString(n, minos.AttrPackageRevision),
+			Config:   attrString(n, minos.AttrConfigRevision),
+		}
+		switch n.Job {
+		case deployment.JobMeta:
+			row.PrimaryMeta = fmt.Sprint(n.IPPort == info.PrimaryMeta)
+		case deployment.JobReplica:
+			// a replica node that never registered to meta is considered unalive
+			row.Status = "unalive"
+			for _, state := range replicaInfo.Nodes {
+				if state.IPPort == n.IPPort {
+					if state.Status == admin.NodeStatus_NS_ALIVE {
+						row.Status = "alive"
+					}
+					row.Primaries = fmt.Sprint(state.PrimariesNum)
+					row.Replicas = fmt.Sprint(state.ReplicaCount)
+					break
+				}
+			}
+		}
+		rows = append(rows, row)
 	}
-	tabular.Print(os.Stdout, rows)
+	tabular.Print(w, rows)
 	return nil
 }
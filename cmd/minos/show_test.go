@@ -0,0 +1,99 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/pegasus-kv/admin-cli/client"
+	"github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// fakeMeta reports node 1 alive and node 2 unalive, node 3 never registers.
+type fakeMeta struct {
+	meta.Meta
+}
+
+func (m *fakeMeta) GetClusterInfo() (*meta.ClusterInfo, error) {
+	return &meta.ClusterInfo{Cluster: "onebox", PrimaryMeta: "127.0.0.1:34601"}, nil
+}
+
+func (m *fakeMeta) GetClusterReplicaInfo() (*client.ClusterReplicaInfo, error) {
+	return &client.ClusterReplicaInfo{Nodes: []*client.NodeState{
+		{IPPort: "127.0.0.1:34801", Status: admin.NodeStatus_NS_ALIVE, PrimariesNum: 4, ReplicaCount: 12},
+		{IPPort: "127.0.0.1:34802", Status: admin.NodeStatus_NS_UNALIVE},
+	}}, nil
+}
+
+// showRow returns the fields of the table row that contains s.
+func showRow(out string, s string) []string {
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, s) {
+			return strings.Fields(strings.ReplaceAll(line, "|", " "))
+		}
+	}
+	return nil
+}
+
+func TestShow(t *testing.T) {
+	useFakeMinos(t)
+	out, _, err := runCommand(t, false, "show", "onebox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sorted by job and then ID
+	if !(strings.Index(out, "pkg-meta") < strings.Index(out, "pkg-1") &&
+		strings.Index(out, "pkg-1") < strings.Index(out, "pkg-2") && strings.Index(out, "pkg-2") < strings.Index(out, "pkg-3")) {
+		t.Errorf("the nodes are not sorted:\n%s", out)
+	}
+	if row := strings.Join(showRow(out, "pkg-1"), " "); !strings.HasPrefix(row, "replica 1 127.0.0.1:34801 ") ||
+		!strings.HasSuffix(row, " pkg-1 conf-1") {
+		t.Errorf("unexpected row %s", row)
+	}
+}
+
+func TestShowWithMeta(t *testing.T) {
+	useFakeMinos(t)
+	old := newMetaClient
+	newMetaClient = func(cluster string, metaList []string) (meta.Meta, error) {
+		if strings.Join(metaList, ",") != "127.0.0.1:34601" {
+			t.Errorf("unexpected metas %v", metaList)
+		}
+		return &fakeMeta{}, nil
+	}
+	defer func() { newMetaClient = old }()
+
+	out, _, err := runCommand(t, false, "show", "onebox", "--meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]string{
+		"pkg-meta": {"pkg-meta", "true"},
+		"pkg-1":    {"pkg-1", "conf-1", "alive", "4", "12"},
+		"pkg-2":    {"pkg-2", "conf-2", "unalive", "0", "0"},
+		"pkg-3":    {"pkg-3", "conf-3", "unalive"},
+	}
+	for key, fields := range tests {
+		row := showRow(out, key)
+		if strings.Join(row[len(row)-len(fields):], " ") != strings.Join(fields, " ") {
+			t.Errorf("unexpected row %v", row)
+		}
+	}
+}
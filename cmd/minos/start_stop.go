@@ -18,24 +18,27 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/manifoldco/promptui"
 	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
 	"github.com/spf13/cobra"
 )
 
 var (
 	startCmd = &cobra.Command{
-		Use:   "start <cluster_name> --task {TaskID} --job {replica|meta|collector} --user {user_name}",
+		Use:   "start <cluster_name> {--task {TaskID}... | --all} --job {replica|meta|collector} [--yes]",
 		Args:  cobra.ExactArgs(1),
-		Short: "Start a single Pegasus node",
+		Short: "Start Pegasus nodes",
 		RunE:  runStart,
 	}
 	stopCmd = &cobra.Command{
-		Use:   "stop <cluster_name> --task {TaskID} --job {replica|meta|collector} --user {user_name}",
+		Use:   "stop <cluster_name> {--task {TaskID}... | --all} --job {replica|meta|collector} [--yes]",
 		Args:  cobra.ExactArgs(1),
-		Short: "Stop a single Pegasus node",
+		Short: "Stop Pegasus nodes",
 		RunE:  runStop,
 	}
 )
@@ -45,61 +48,94 @@ func init() {
 	registerOpCmdFlags(stopCmd)
 }
 
+// confirm asks the user to type 'y' to confirm, changeable in tests
+var confirm = func() bool {
+	prompt := promptui.Prompt{
+		Label:     "Please type 'y' to confirm",
+		IsConfirm: true,
+	}
+	_, err := prompt.Run()
+	return err == nil
+}
+
 func registerOpCmdFlags(cmd *cobra.Command) {
 	cmd.Flags().String("job", "", "The type of node to operate. Options: replica|meta|collector")
-	cmd.Flags().Int("task", -1, "The node ID to operate.")
+	cmd.Flags().IntSlice("task", nil, "The node IDs to operate, can be repeated or comma-separated.")
+	cmd.Flags().Bool("all", false, "Operate all nodes of the job.")
+	cmd.Flags().BoolP("yes", "y", false, "Skip the confirmation, useful in scripts.")
+	cmd.Flags().Int("concurrency", 1, "The number of nodes that minos operates simultaneously.")
+	cmd.Flags().Int("step", 0, "The step parameter passed to minos as-is.")
 	_ = cmd.MarkFlagRequired("job")
-	_ = cmd.MarkFlagRequired("task")
 }
 
-type nodeOpFunc func(m deployment.Deployment, node deployment.Node) error
-
-func runNodeOp(cmd *cobra.Command, args []string, op nodeOpFunc) error {
+func runNodeOp(cmd *cobra.Command, args []string, action string, update minos.UpdateOptions) error {
 	cluster := args[0]
-	taskID, _ := cmd.Flags().GetInt("task")
+	taskIDs, _ := cmd.Flags().GetIntSlice("task")
+	all, _ := cmd.Flags().GetBool("all")
+	yes, _ := cmd.Flags().GetBool("yes")
+	opts := minos.BatchOptions{UpdateOptions: update}
+	opts.Concurrency, _ = cmd.Flags().GetInt("concurrency")
+	opts.Step, _ = cmd.Flags().GetInt("step")
 
-	var jobType deployment.JobType
 	jobArg, _ := cmd.Flags().GetString("job")
-	switch jobArg {
-	case "replica":
-		jobType = deployment.JobReplica
-	case "meta":
-		jobType = deployment.JobMeta
-	case "collector":
-		jobType = deployment.JobCollector
-	default:
-		return fmt.Errorf("unrecognized type of node \"%s\"", jobArg)
+	jobType, err := deployment.ParseJobType(jobArg)
+	if err != nil {
+		return err
 	}
-
-	// Require confirmation to proceed. This is to prevent mis-operation.
-	prompt := promptui.Prompt{
-		Label:     "Please type 'y' to confirm",
-		IsConfirm: true,
+	if all == (len(taskIDs) != 0) {
+		return errors.New("either --task or --all should be specified")
 	}
-	_, err := prompt.Run()
-	if err != nil {
-		fmt.Printf("Cancelled operation \"%s\" on %s %s %d\n", cmd.Name(), cluster, jobArg, taskID)
-		return nil
+	if opts.Concurrency <= 0 {
+		return fmt.Errorf("invalid concurrency %d", opts.Concurrency)
+	}
+	if opts.Step < 0 {
+		return fmt.Errorf("invalid step %d", opts.Step)
 	}
+	// the arguments are valid, errors since now are not caused by misuse
+	cmd.SilenceUsage = true
 
-	m := deployment.NewMinos(cluster, "")
-	err = op(m, deployment.Node{Name: fmt.Sprint(taskID), Job: jobType})
+	m, err := newMinos(cluster, "")
 	if err != nil {
-		fmt.Println(err.Error())
-	} else {
-		fmt.Println("Success")
+		return err
+	}
+	if all {
+		nodes, err := m.ListAllNodes()
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			if n.Job == jobType {
+				id, _ := strconv.Atoi(n.Name)
+				taskIDs = append(taskIDs, id)
+			}
+		}
+		if len(taskIDs) == 0 {
+			return fmt.Errorf("no %s node found in cluster %s", jobArg, cluster)
+		}
+	}
+
+	if !yes {
+		fmt.Fprintf(cmd.OutOrStdout(), "%s %s %s %v\n", cmd.Name(), cluster, jobArg, taskIDs)
+		if update.PackageRevision != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "package revision: %s\n", update.PackageRevision)
+		}
+		// Require confirmation to proceed. This is to prevent mis-operation.
+		if !confirm() {
+			return fmt.Errorf("cancelled operation \"%s\" on %s %s %v", cmd.Name(), cluster, jobArg, taskIDs)
+		}
+	}
+
+	if err := m.BatchOp(action, jobType, taskIDs, opts); err != nil {
+		return err
 	}
+	fmt.Fprintln(cmd.OutOrStdout(), "Success")
 	return nil
 }
 
 func runStart(cmd *cobra.Command, args []string) error {
-	return runNodeOp(cmd, args, func(m deployment.Deployment, node deployment.Node) error {
-		return m.StartNode(node)
-	})
+	return runNodeOp(cmd, args, "start", minos.UpdateOptions{})
 }
 
 func runStop(cmd *cobra.Command, args []string) error {
-	return runNodeOp(cmd, args, func(m deployment.Deployment, node deployment.Node) error {
-		return m.StopNode(node)
-	})
+	return runNodeOp(cmd, args, "stop", minos.UpdateOptions{})
 }
@@ -0,0 +1,109 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment/minos"
+)
+
+func TestStartStop(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// the expected error, empty means success
+		err   string
+		calls []string
+	}{
+		{name: "tasks", args: []string{"start", "onebox", "--job", "replica", "--task", "1,2", "--task", "3", "--yes"},
+			calls: []string{"start replica [1 2 3]"}},
+		{name: "all", args: []string{"stop", "onebox", "--job", "replica", "--all", "-y"},
+			calls: []string{"stop replica [3 1 2]"}},
+		{name: "all of a job without nodes", args: []string{"start", "onebox", "--job", "collector", "--all", "--yes"},
+			err: "no collector node found"},
+		{name: "neither tasks nor all", args: []string{"start", "onebox", "--job", "replica", "--yes"},
+			err: "either --task or --all"},
+		{name: "both tasks and all", args: []string{"stop", "onebox", "--job", "replica", "--task", "1", "--all", "--yes"},
+			err: "either --task or --all"},
+		{name: "unknown job", args: []string{"start", "onebox", "--job", "rs", "--task", "1", "--yes"},
+			err: "unrecognized job"},
+		{name: "job missing", args: []string{"start", "onebox", "--task", "1", "--yes"},
+			err: "\"job\" not set"},
+		{name: "invalid concurrency", args: []string{"start", "onebox", "--job", "replica", "--task", "1", "--concurrency", "0", "--yes"},
+			err: "invalid concurrency 0"},
+		{name: "invalid step", args: []string{"start", "onebox", "--job", "replica", "--task", "1", "--step", "-1", "--yes"},
+			err: "invalid step -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := useFakeMinos(t)
+			out, asked, err := runCommand(t, false, tt.args...)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(f.calls, ",") != strings.Join(tt.calls, ",") {
+				t.Errorf("unexpected operations %v", f.calls)
+			}
+			if tt.err == "" && !strings.Contains(out, "Success") {
+				t.Errorf("unexpected output %s", out)
+			}
+			if asked {
+				t.Error("--yes should skip the confirmation")
+			}
+		})
+	}
+}
+
+func TestStartStopOptions(t *testing.T) {
+	f := useFakeMinos(t)
+	if _, _, err := runCommand(t, false, "stop", "onebox", "--job", "meta", "--all", "--concurrency", "3", "--step", "2", "--yes"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.calls, ",") != "stop meta [1]" {
+		t.Errorf("unexpected operations %v", f.calls)
+	}
+	if f.opts != (minos.BatchOptions{Concurrency: 3, Step: 2}) {
+		t.Errorf("unexpected options %+v", f.opts)
+	}
+}
+
+func TestStartStopConfirmation(t *testing.T) {
+	f := useFakeMinos(t)
+	out, asked, err := runCommand(t, false, "stop", "onebox", "--job", "replica", "--task", "1")
+	if !asked || err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("expect the operation cancelled, got %v", err)
+	}
+	if !strings.Contains(out, "stop onebox replica [1]") {
+		t.Errorf("the operation should be shown before confirmation: %s", out)
+	}
+	if len(f.calls) != 0 {
+		t.Fatalf("unexpected operations %v", f.calls)
+	}
+
+	if _, _, err := runCommand(t, true, "stop", "onebox", "--job", "replica", "--task", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(f.calls, ",") != "stop replica [1]" {
+		t.Errorf("unexpected operations %v", f.calls)
+	}
+}
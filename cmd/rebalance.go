@@ -0,0 +1,43 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	primaryOnly bool
+
+	rebalanceCmd = &cobra.Command{
+		Use:   "rebalance",
+		Short: "Balance the replicas among the replica nodes",
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, nil, func(deploy deployment.Deployment) error {
+				return pegasus.Rebalance(cluster, deploy, primaryOnly)
+			})
+		},
+	}
+)
+
+func init() {
+	rebalanceCmd.Flags().BoolVar(&primaryOnly, "primary-only", false,
+		"only balance the primaries by switching roles, which copies no data")
+}
@@ -0,0 +1,46 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cmd
+
+import (
+	pegasus "github.com/pegasus-kv/cluster-cli"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/spf13/cobra"
+)
+
+var (
+	oldNode string
+	newNode string
+
+	replaceNodeCmd = &cobra.Command{
+		Use:   "replace-node",
+		Short: "Replace a replica node with a new one",
+		Run: func(cmd *cobra.Command, args []string) {
+			runClusterOp(cmd, []string{oldNode, newNode}, func(deploy deployment.Deployment) error {
+				return pegasus.ReplaceNode(cluster, deploy, oldNode, newNode, limits)
+			})
+		},
+	}
+)
+
+func init() {
+	replaceNodeCmd.Flags().StringVar(&oldNode, "old", "", "the replica node to be replaced")
+	replaceNodeCmd.Flags().StringVar(&newNode, "new", "", "the replica node to replace with")
+	_ = replaceNodeCmd.MarkFlagRequired("old")
+	_ = replaceNodeCmd.MarkFlagRequired("new")
+}
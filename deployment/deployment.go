@@ -18,6 +18,8 @@
 package deployment
 
 import (
+	"fmt"
+
 	"github.com/XiaoMi/pegasus-go-client/session"
 	"github.com/pegasus-kv/admin-cli/util"
 )
@@ -49,6 +51,25 @@ type Deployment interface {
 	Name() string
 }
 
+// Restarter is an optional interface of Deployment that restarts a node in place
+// without changing its version. A possible implementation may simply restart the
+// process via supervisord.
+type Restarter interface {
+	RestartNode(Node) error
+}
+
+// RestartNode restarts the node by the deployment. It stops and then starts the node
+// if the deployment is not a Restarter.
+func RestartNode(d Deployment, node Node) error {
+	if r, ok := d.(Restarter); ok {
+		return r.RestartNode(node)
+	}
+	if err := d.StopNode(node); err != nil {
+		return err
+	}
+	return d.StartNode(node)
+}
+
 // CreateDeployment creates a non-nil instance of Deployment that binds to a specific cluster.
 var CreateDeployment func(cluster string) Deployment = nil
 
@@ -100,6 +121,16 @@ const (
 	JobCollector = 2
 )
 
+// ParseJobType parses the name of a job, i.e. meta, replica or collector.
+func ParseJobType(name string) (JobType, error) {
+	for _, j := range []JobType{JobMeta, JobReplica, JobCollector} {
+		if j.String() == name {
+			return j, nil
+		}
+	}
+	return 0, fmt.Errorf("unrecognized job \"%s\"", name)
+}
+
 func (j JobType) String() string {
 	switch j {
 	case JobMeta:
@@ -0,0 +1,141 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config describes the REST API of a deployment service. The URLs, headers and bodies
+// are Go templates executed over the node being operated, with the fields of
+// deployment.Node and the cluster name, e.g. "{{.Cluster}}/nodes/{{.Name}}" or
+// `{"job": "{{.Job}}", "addr": {{json .IPPort}}}`.
+//
+// An example that operates the nodes via a service at http://deployer:
+//
+//	{
+//	  "name": "deployer",
+//	  "headers": {"Authorization": "Bearer ${DEPLOYER_TOKEN}"},
+//	  "start_node": {"url": "http://deployer/clusters/{{.Cluster}}/nodes/{{.Name}}/start"},
+//	  "stop_node": {"url": "http://deployer/clusters/{{.Cluster}}/nodes/{{.Name}}/stop"},
+//	  "rolling_update": {
+//	    "url": "http://deployer/clusters/{{.Cluster}}/nodes/{{.Name}}/upgrade",
+//	    "success": {"path": "result", "equals": "ok", "error_path": "message"}
+//	  },
+//	  "list_nodes": {
+//	    "method": "GET",
+//	    "url": "http://deployer/clusters/{{.Cluster}}/nodes",
+//	    "nodes_path": "nodes",
+//	    "name_path": "id",
+//	    "address_path": "addr",
+//	    "job_path": "role",
+//	    "job_values": {"rs": "replica", "ms": "meta", "collector": "collector"},
+//	    "attr_paths": {"Status": "state"}
+//	  }
+//	}
+type Config struct {
+	// Name of the deployment system, "httpapi" if empty.
+	Name string `json:"name,omitempty"`
+
+	// The headers sent in every request. Environment variables like ${TOKEN} are expanded,
+	// so that credentials need not be written in the file.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The timeout of a single request, e.g. "30s". Defaults to 1 minute.
+	Timeout string `json:"timeout,omitempty"`
+
+	StartNode     *Endpoint `json:"start_node"`
+	StopNode      *Endpoint `json:"stop_node"`
+	RollingUpdate *Endpoint `json:"rolling_update"`
+
+	// Optional, the node is stopped and then started if absent.
+	RestartNode *Endpoint `json:"restart_node,omitempty"`
+
+	ListNodes *ListEndpoint `json:"list_nodes"`
+}
+
+// Endpoint is an API that operates a node.
+type Endpoint struct {
+	// The HTTP method, "POST" if empty.
+	Method string `json:"method,omitempty"`
+
+	// The template of the URL.
+	URL string `json:"url"`
+
+	// The templates of the headers in addition to the common ones.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The template of the request body, no body is sent if empty.
+	Body string `json:"body,omitempty"`
+
+	// How to tell whether the request succeeds, any 2xx response is a success if absent.
+	Success *SuccessPredicate `json:"success,omitempty"`
+}
+
+// SuccessPredicate tells whether a response represents a success.
+// The paths are in the syntax of github.com/tidwall/gjson, e.g. "retval.code".
+type SuccessPredicate struct {
+	// The acceptable status codes, any 2xx if empty.
+	StatusCodes []int `json:"status_codes,omitempty"`
+
+	// The field in the JSON response that indicates the success. Not checked if empty.
+	Path string `json:"path,omitempty"`
+
+	// The expected value of the field. If empty, the field must be true.
+	Equals string `json:"equals,omitempty"`
+
+	// The field in the JSON response that explains the failure.
+	ErrorPath string `json:"error_path,omitempty"`
+}
+
+// ListEndpoint is an API that lists all nodes of the cluster. The templates are executed
+// with only the cluster name.
+type ListEndpoint struct {
+	Endpoint
+
+	// The path of the array of nodes in the response, the response itself if empty.
+	NodesPath string `json:"nodes_path,omitempty"`
+
+	// The paths of the node fields, relative to each node.
+	// The address is used as the name if NamePath is empty.
+	NamePath    string `json:"name_path,omitempty"`
+	AddressPath string `json:"address_path"`
+	JobPath     string `json:"job_path"`
+
+	// Maps the job values in the response to "meta", "replica" or "collector".
+	// The values are expected to be one of them if empty.
+	JobValues map[string]string `json:"job_values,omitempty"`
+
+	// The paths of the additional attributes of the node, e.g. {"Status": "state"}.
+	AttrPaths map[string]string `json:"attr_paths,omitempty"`
+}
+
+// LoadConfig reads the config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid httpapi config file %s: %s", path, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,341 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httpapi implements a Deployment that operates the nodes through the REST API
+// of a deployment service, which is described by a config rather than code.
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	"github.com/tidwall/gjson"
+)
+
+const defaultTimeout = time.Minute
+
+type httpDeployment struct {
+	client *resty.Client
+
+	cluster string
+	name    string
+
+	startNode     *endpoint
+	stopNode      *endpoint
+	rollingUpdate *endpoint
+	// nil if the service has no restart API
+	restartNode *endpoint
+
+	listNodes *endpoint
+	listCfg   *ListEndpoint
+}
+
+// New returns a Deployment of the cluster that calls the APIs in the config.
+// All templates are validated here.
+func New(cluster string, cfg *Config) (deployment.Deployment, error) {
+	d := &httpDeployment{
+		cluster: cluster,
+		name:    cfg.Name,
+		listCfg: cfg.ListNodes,
+	}
+	if d.name == "" {
+		d.name = "httpapi"
+	}
+
+	timeout := defaultTimeout
+	if cfg.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout is not a valid duration: \"%s\"", cfg.Timeout)
+		}
+	}
+	d.client = resty.New().SetTimeout(timeout)
+	for k, v := range cfg.Headers {
+		d.client.SetHeader(k, os.ExpandEnv(v))
+	}
+
+	var err error
+	if d.startNode, err = compileEndpoint("start_node", cfg.StartNode); err != nil {
+		return nil, err
+	}
+	if d.stopNode, err = compileEndpoint("stop_node", cfg.StopNode); err != nil {
+		return nil, err
+	}
+	if d.rollingUpdate, err = compileEndpoint("rolling_update", cfg.RollingUpdate); err != nil {
+		return nil, err
+	}
+	if cfg.RestartNode != nil {
+		if d.restartNode, err = compileEndpoint("restart_node", cfg.RestartNode); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ListNodes == nil {
+		return nil, errors.New("list_nodes is not configured")
+	}
+	if cfg.ListNodes.AddressPath == "" || cfg.ListNodes.JobPath == "" {
+		return nil, errors.New("list_nodes: address_path and job_path are required")
+	}
+	if d.listNodes, err = compileEndpoint("list_nodes", &cfg.ListNodes.Endpoint); err != nil {
+		return nil, err
+	}
+	if d.restartNode != nil {
+		return &restartableDeployment{d}, nil
+	}
+	return d, nil
+}
+
+// templateData is what the templates of an endpoint are executed over.
+type templateData struct {
+	deployment.Node
+
+	Cluster string
+}
+
+var templateFuncs = template.FuncMap{
+	// json quotes the value as a JSON literal, e.g. `{"addr": {{json .IPPort}}}`
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type endpoint struct {
+	name string
+
+	method  string
+	url     *template.Template
+	headers map[string]*template.Template
+	// nil if no body is sent
+	body    *template.Template
+	success *SuccessPredicate
+}
+
+func compileEndpoint(name string, e *Endpoint) (*endpoint, error) {
+	if e == nil {
+		return nil, fmt.Errorf("%s is not configured", name)
+	}
+	if e.URL == "" {
+		return nil, fmt.Errorf("%s: url is required", name)
+	}
+	ep := &endpoint{
+		name:    name,
+		method:  strings.ToUpper(e.Method),
+		headers: map[string]*template.Template{},
+		success: e.Success,
+	}
+	if ep.method == "" {
+		ep.method = http.MethodPost
+	}
+
+	var err error
+	parse := func(field string, text string) *template.Template {
+		if err != nil {
+			return nil
+		}
+		var t *template.Template
+		t, err = template.New(name + "." + field).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+		if err != nil {
+			err = fmt.Errorf("%s: invalid template of %s: %s", name, field, err)
+		}
+		return t
+	}
+	ep.url = parse("url", e.URL)
+	for k, v := range e.Headers {
+		ep.headers[k] = parse("headers."+k, v)
+	}
+	if e.Body != "" {
+		ep.body = parse("body", e.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ep, nil
+}
+
+func render(t *template.Template, data *templateData) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// call sends the request, and returns the response body if the request succeeds.
+func (e *endpoint) call(client *resty.Client, data *templateData) ([]byte, error) {
+	url, err := render(e.url, data)
+	if err != nil {
+		return nil, err
+	}
+	req := client.R()
+	for k, t := range e.headers {
+		v, err := render(t, data)
+		if err != nil {
+			return nil, err
+		}
+		req.SetHeader(k, v)
+	}
+	if e.body != nil {
+		body, err := render(e.body, data)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBody(body)
+	}
+
+	resp, err := req.Execute(e.method, url)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.success.check(resp); err != nil {
+		return nil, &deployment.RetryableError{
+			Message:   fmt.Sprintf("%s failed: %s %s: %s", e.name, e.method, url, err),
+			Transient: deployment.IsRetryableStatus(resp.StatusCode()),
+		}
+	}
+	return resp.Body(), nil
+}
+
+// check returns nil if the response is a success, otherwise the reason of failure.
+// A nil predicate accepts any 2xx response.
+func (p *SuccessPredicate) check(resp *resty.Response) error {
+	failure := func() error {
+		if p != nil && p.ErrorPath != "" {
+			if msg := gjson.GetBytes(resp.Body(), p.ErrorPath); msg.Exists() {
+				return fmt.Errorf("%s, %s", resp.Status(), msg.String())
+			}
+		}
+		return fmt.Errorf("%s, response: %s", resp.Status(), resp.Body())
+	}
+
+	if p == nil || len(p.StatusCodes) == 0 {
+		if !resp.IsSuccess() {
+			return failure()
+		}
+	} else {
+		accepted := false
+		for _, code := range p.StatusCodes {
+			if resp.StatusCode() == code {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return failure()
+		}
+	}
+
+	if p == nil || p.Path == "" {
+		return nil
+	}
+	field := gjson.GetBytes(resp.Body(), p.Path)
+	if p.Equals == "" && field.Bool() || p.Equals != "" && field.String() == p.Equals {
+		return nil
+	}
+	return failure()
+}
+
+func (d *httpDeployment) operate(e *endpoint, node deployment.Node) error {
+	_, err := e.call(d.client, &templateData{Node: node, Cluster: d.cluster})
+	return err
+}
+
+func (d *httpDeployment) StartNode(node deployment.Node) error {
+	return d.operate(d.startNode, node)
+}
+
+func (d *httpDeployment) StopNode(node deployment.Node) error {
+	return d.operate(d.stopNode, node)
+}
+
+func (d *httpDeployment) RollingUpdate(node deployment.Node) error {
+	return d.operate(d.rollingUpdate, node)
+}
+
+// restartableDeployment is a deployment.Restarter, for the service that has the restart API.
+type restartableDeployment struct {
+	*httpDeployment
+}
+
+func (d *restartableDeployment) RestartNode(node deployment.Node) error {
+	return d.operate(d.restartNode, node)
+}
+
+func (d *httpDeployment) ListAllNodes() ([]deployment.Node, error) {
+	body, err := d.listNodes.call(d.client, &templateData{Cluster: d.cluster})
+	if err != nil {
+		return nil, err
+	}
+
+	list := gjson.ParseBytes(body)
+	if d.listCfg.NodesPath != "" {
+		list = list.Get(d.listCfg.NodesPath)
+	}
+	if !list.IsArray() {
+		return nil, fmt.Errorf("list_nodes: the nodes are not an array: %s", body)
+	}
+
+	var nodes []deployment.Node
+	for _, item := range list.Array() {
+		addr := item.Get(d.listCfg.AddressPath).String()
+		if addr == "" {
+			return nil, fmt.Errorf("list_nodes: no address at \"%s\" in %s", d.listCfg.AddressPath, item.Raw)
+		}
+		job, err := d.parseJob(item.Get(d.listCfg.JobPath).String())
+		if err != nil {
+			return nil, err
+		}
+		name := addr
+		if d.listCfg.NamePath != "" {
+			name = item.Get(d.listCfg.NamePath).String()
+		}
+
+		node := deployment.NewNode(name, addr, job)
+		for attr, path := range d.listCfg.AttrPaths {
+			if v := item.Get(path); v.Exists() {
+				node.Attrs[attr] = v.Value()
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (d *httpDeployment) parseJob(val string) (deployment.JobType, error) {
+	jobName := val
+	if len(d.listCfg.JobValues) != 0 {
+		jobName = d.listCfg.JobValues[val]
+	}
+	job, err := deployment.ParseJobType(jobName)
+	if err != nil {
+		return 0, fmt.Errorf("list_nodes: unrecognized job \"%s\"", val)
+	}
+	return job, nil
+}
+
+func (d *httpDeployment) Name() string {
+	return d.name
+}
@@ -0,0 +1,223 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+func newTestServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *[]request) {
+	var reqs []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		reqs = append(reqs, request{method: r.Method, path: r.URL.Path, header: r.Header, body: string(body)})
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &reqs
+}
+
+func testConfig(url string) *Config {
+	return &Config{
+		Name:    "deployer",
+		Headers: map[string]string{"Authorization": "Bearer ${HTTPAPI_TEST_TOKEN}"},
+		StartNode: &Endpoint{
+			URL:  url + "/clusters/{{.Cluster}}/nodes/{{.Name}}/start",
+			Body: `{"job": "{{.Job}}", "addr": {{json .IPPort}}}`,
+		},
+		StopNode: &Endpoint{
+			Method:  "put",
+			URL:     url + "/clusters/{{.Cluster}}/nodes/{{.Name}}/stop",
+			Headers: map[string]string{"X-Job": "{{.Job}}"},
+		},
+		RollingUpdate: &Endpoint{
+			URL:     url + "/clusters/{{.Cluster}}/nodes/{{.Name}}/upgrade",
+			Success: &SuccessPredicate{Path: "result", Equals: "ok", ErrorPath: "message"},
+		},
+		ListNodes: &ListEndpoint{
+			Endpoint:    Endpoint{Method: "GET", URL: url + "/clusters/{{.Cluster}}/nodes"},
+			NodesPath:   "nodes",
+			NamePath:    "id",
+			AddressPath: "addr",
+			JobPath:     "role",
+			JobValues:   map[string]string{"rs": "replica", "ms": "meta"},
+			AttrPaths:   map[string]string{deployment.AttrStatus: "state"},
+		},
+	}
+}
+
+func TestOperateNode(t *testing.T) {
+	os.Setenv("HTTPAPI_TEST_TOKEN", "secret")
+	defer os.Unsetenv("HTTPAPI_TEST_TOKEN")
+	srv, reqs := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	d, err := New("onebox", testConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := deployment.Node{Name: "r1", Job: deployment.JobReplica, IPPort: "127.0.0.1:34801"}
+
+	if err := d.StartNode(node); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StopNode(node); err != nil {
+		t.Fatal(err)
+	}
+	// no restart API, the node is stopped and started
+	if err := deployment.RestartNode(d, node); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []request{
+		{method: "POST", path: "/clusters/onebox/nodes/r1/start", body: `{"job": "replica", "addr": "127.0.0.1:34801"}`},
+		{method: "PUT", path: "/clusters/onebox/nodes/r1/stop"},
+		{method: "PUT", path: "/clusters/onebox/nodes/r1/stop"},
+		{method: "POST", path: "/clusters/onebox/nodes/r1/start", body: `{"job": "replica", "addr": "127.0.0.1:34801"}`},
+	}
+	if len(*reqs) != len(expected) {
+		t.Fatalf("expect %d requests, got %d", len(expected), len(*reqs))
+	}
+	for i, r := range *reqs {
+		if r.method != expected[i].method || r.path != expected[i].path || r.body != expected[i].body {
+			t.Errorf("request %d: expect %+v, got %+v", i, expected[i], r)
+		}
+		if r.header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("request %d: unexpected Authorization header %q", i, r.header.Get("Authorization"))
+		}
+	}
+	if (*reqs)[1].header.Get("X-Job") != "replica" {
+		t.Errorf("unexpected X-Job header %q", (*reqs)[1].header.Get("X-Job"))
+	}
+}
+
+func TestRestartNode(t *testing.T) {
+	srv, reqs := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+	cfg := testConfig(srv.URL)
+	d, err := New("onebox", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.(deployment.Restarter); ok {
+		t.Error("the deployment without restart API should not be a Restarter")
+	}
+
+	cfg.RestartNode = &Endpoint{URL: srv.URL + "/clusters/{{.Cluster}}/nodes/{{.Name}}/restart"}
+	d, err = New("onebox", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := deployment.RestartNode(d, deployment.Node{Name: "r1", Job: deployment.JobReplica}); err != nil {
+		t.Fatal(err)
+	}
+	if len(*reqs) != 1 || (*reqs)[0].method != "POST" || (*reqs)[0].path != "/clusters/onebox/nodes/r1/restart" {
+		t.Errorf("unexpected requests %+v", *reqs)
+	}
+}
+
+func TestSuccessPredicate(t *testing.T) {
+	var status int
+	var resp string
+	srv, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(resp))
+	})
+	d, err := New("onebox", testConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := deployment.Node{Name: "r1", Job: deployment.JobReplica}
+
+	status, resp = http.StatusOK, `{"result": "ok"}`
+	if err := d.RollingUpdate(node); err != nil {
+		t.Error(err)
+	}
+
+	status, resp = http.StatusOK, `{"result": "failed", "message": "no such package"}`
+	err = d.RollingUpdate(node)
+	if err == nil || !strings.Contains(err.Error(), "no such package") || deployment.IsRetryable(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	status, resp = http.StatusBadGateway, ``
+	if err := d.RollingUpdate(node); !deployment.IsRetryable(err) {
+		t.Errorf("HTTP 502 should be retryable: %v", err)
+	}
+
+	status, resp = http.StatusNotFound, ``
+	if err := d.StartNode(node); err == nil || deployment.IsRetryable(err) {
+		t.Errorf("HTTP 404 should fail without retry: %v", err)
+	}
+}
+
+func TestListAllNodes(t *testing.T) {
+	srv, _ := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"nodes": [
+  {"id": "m0", "addr": "127.0.0.1:34601", "role": "ms", "state": "Running"},
+  {"id": "r1", "addr": "127.0.0.1:34801", "role": "rs"}
+]}`))
+	})
+	d, err := New("onebox", testConfig(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := d.ListAllNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expect 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Name != "m0" || nodes[0].Job != deployment.JobMeta || nodes[0].IPPort != "127.0.0.1:34601" ||
+		nodes[0].Attrs[deployment.AttrStatus] != deployment.NodeStatusRunning {
+		t.Errorf("unexpected node %+v", nodes[0])
+	}
+	if nodes[1].Name != "r1" || nodes[1].Job != deployment.JobReplica || len(nodes[1].Attrs) != 0 {
+		t.Errorf("unexpected node %+v", nodes[1])
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, modify := range []func(*Config){
+		func(c *Config) { c.StartNode = nil },
+		func(c *Config) { c.StopNode.URL = "" },
+		func(c *Config) { c.RollingUpdate.Body = "{{.Name" },
+		func(c *Config) { c.ListNodes = nil },
+		func(c *Config) { c.ListNodes.AddressPath = "" },
+		func(c *Config) { c.Timeout = "1 minute" },
+	} {
+		cfg := testConfig("http://deployer")
+		modify(cfg)
+		if _, err := New("onebox", cfg); err == nil {
+			t.Errorf("expect error for config %+v", cfg)
+		}
+	}
+}
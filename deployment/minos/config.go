@@ -0,0 +1,196 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the settings of minos for a cluster.
+type Config struct {
+	// the minos api service url
+	APIURL string `json:"api_url,omitempty"`
+
+	// the pegasus team's MiCloud orgID
+	OrgID int `json:"org_id,omitempty"`
+
+	// the pegasus gateway url
+	GatewayURL string `json:"gateway_url,omitempty"`
+
+	// The file that contains the token to access minos and the gateway.
+	// No credential is sent if empty.
+	TokenFile string `json:"token_file,omitempty"`
+
+	// The timeout of a single HTTP request, e.g. "30s".
+	RequestTimeout string `json:"request_timeout,omitempty"`
+
+	// How long to wait for a minos operation to complete, e.g. "1h".
+	OpTimeout string `json:"op_timeout,omitempty"`
+
+	// The minos error codes that represent transient failures.
+	RetryableErrorCodes []int `json:"retryable_error_codes,omitempty"`
+}
+
+// ConfigFile is the content of the minos config file, for example:
+//
+//	{
+//	  "default": {"api_url": "http://minos", "org_id": 1, "gateway_url": "http://gateway"},
+//	  "clusters": {
+//	    "onebox": {"api_url": "http://minos-staging", "token_file": "/etc/minos/token"}
+//	  }
+//	}
+//
+// The settings of a cluster override the default ones.
+type ConfigFile struct {
+	Default  Config            `json:"default"`
+	Clusters map[string]Config `json:"clusters,omitempty"`
+}
+
+const defaultRequestTimeout = time.Minute
+
+// DefaultConfigPath returns the location of the minos config file, which can be
+// changed by the environment variable MINOS_CONFIG.
+func DefaultConfigPath() string {
+	if path := os.Getenv("MINOS_CONFIG"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "minos.json"
+	}
+	return filepath.Join(home, ".pegasus-cluster-cli", "minos.json")
+}
+
+// LoadConfig returns the settings of the cluster from the config file at path,
+// overridden by the environment variables if set. A missing file is not an error,
+// in which case all settings come from the environment variables.
+func LoadConfig(path string, cluster string) (*Config, error) {
+	var file ConfigFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("invalid minos config file %s: %s", path, err)
+		}
+	}
+
+	cfg := file.Default
+	cfg.merge(file.Clusters[cluster])
+	if err := cfg.mergeEnv(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// merge overrides the settings with the ones that are set in o.
+func (c *Config) merge(o Config) {
+	if o.APIURL != "" {
+		c.APIURL = o.APIURL
+	}
+	if o.OrgID != 0 {
+		c.OrgID = o.OrgID
+	}
+	if o.GatewayURL != "" {
+		c.GatewayURL = o.GatewayURL
+	}
+	if o.TokenFile != "" {
+		c.TokenFile = o.TokenFile
+	}
+	if o.RequestTimeout != "" {
+		c.RequestTimeout = o.RequestTimeout
+	}
+	if o.OpTimeout != "" {
+		c.OpTimeout = o.OpTimeout
+	}
+	if o.RetryableErrorCodes != nil {
+		c.RetryableErrorCodes = o.RetryableErrorCodes
+	}
+}
+
+func (c *Config) mergeEnv() error {
+	var o Config
+	o.APIURL = os.Getenv("MINOS_API_URL")
+	if orgIDEnvVal := os.Getenv("PEGASUS_TEAM_ORG_ID"); orgIDEnvVal != "" {
+		var err error
+		o.OrgID, err = strconv.Atoi(orgIDEnvVal)
+		if err != nil {
+			return fmt.Errorf("PEGASUS_TEAM_ORG_ID is not a valid integer: \"%s\"", orgIDEnvVal)
+		}
+	}
+	o.GatewayURL = os.Getenv("PEGASUS_GATEWAY_URL")
+	o.TokenFile = os.Getenv("MINOS_TOKEN_FILE")
+	o.RequestTimeout = os.Getenv("MINOS_REQUEST_TIMEOUT")
+	o.OpTimeout = os.Getenv("MINOS_OP_TIMEOUT")
+	if codesEnvVal := os.Getenv("MINOS_RETRYABLE_ERROR_CODES"); codesEnvVal != "" {
+		for _, codeStr := range strings.Split(codesEnvVal, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(codeStr))
+			if err != nil {
+				return fmt.Errorf("MINOS_RETRYABLE_ERROR_CODES is not a list of integers: \"%s\"", codesEnvVal)
+			}
+			o.RetryableErrorCodes = append(o.RetryableErrorCodes, code)
+		}
+	}
+	c.merge(o)
+	return nil
+}
+
+func (c *Config) validate() error {
+	if c.APIURL == "" {
+		return errors.New("minos api_url is not configured, please set it in the config file or MINOS_API_URL")
+	}
+	if c.OrgID == 0 {
+		return errors.New("minos org_id is not configured, please set it in the config file or PEGASUS_TEAM_ORG_ID")
+	}
+	if c.GatewayURL == "" {
+		return errors.New("minos gateway_url is not configured, please set it in the config file or PEGASUS_GATEWAY_URL")
+	}
+	return nil
+}
+
+func parseDurationOr(name string, val string, def time.Duration) (time.Duration, error) {
+	if val == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: \"%s\"", name, val)
+	}
+	return d, nil
+}
+
+func readToken(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read minos token: %s", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("minos token file %s is empty", path)
+	}
+	return token, nil
+}
@@ -21,11 +21,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http/httputil"
-	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/pegasus-kv/cluster-cli/deployment"
+	log "github.com/sirupsen/logrus"
 )
 
 type minosDeployment struct {
@@ -42,42 +44,115 @@ type minosDeployment struct {
 	minosAPIAddress string
 	// the pegasus gateway url
 	pegasusGatewayURL string
+
+	// how long to wait for a minos operation to complete
+	opTimeout time.Duration
+
+	// the minos error codes that represent transient failures
+	retryableErrorCodes map[int]bool
+
+	// what the operations via the Deployment interface update
+	update UpdateOptions
 }
 
-// NewMinos returns a deployment of Minos.
-func NewMinos(cluster string, userName string) deployment.Deployment {
-	d := &minosDeployment{
-		cluster:  cluster,
-		userName: userName,
-	}
+// Minos is a Deployment that additionally supports operating multiple nodes in one operation.
+type Minos interface {
+	deployment.Deployment
+
+	// BatchOp performs the action (start|stop|restart|rolling_update) on the tasks of a job
+	// in a single minos operation, and waits for it to complete.
+	BatchOp(action string, job deployment.JobType, taskIDs []int, opts BatchOptions) error
+
+	// SetUpdateOptions specifies what the single-node operations via the Deployment
+	// interface update, e.g. a rolling update driven by pegasus-cluster-cli.
+	SetUpdateOptions(UpdateOptions)
+}
+
+// UpdateOptions selects the package and config that an operation deploys.
+// The zero value deploys the latest package and config.
+type UpdateOptions struct {
+	// Keep the package of the node unchanged, e.g. to push the config only.
+	SkipPackage bool
+
+	// Keep the config of the node unchanged.
+	SkipConfig bool
 
-	d.minosAPIAddress = os.Getenv("MINOS_API_URL")
-	if d.minosAPIAddress == "" {
-		panic("Please set the environment variable MINOS_API_URL")
+	// The package revision to deploy, empty means the latest one.
+	PackageRevision string
+}
+
+// BatchOptions are the minos-side parameters of an operation on multiple tasks.
+type BatchOptions struct {
+	// The number of tasks that are operated simultaneously.
+	Concurrency int
+
+	// Passed to minos as-is, 0 means operating all tasks without pause.
+	Step int
+
+	UpdateOptions
+}
+
+// The attributes of a minos node, absent if the pegasus gateway doesn't report them.
+const (
+	AttrPackageRevision = "PackageRevision"
+	AttrConfigRevision  = "ConfigRevision"
+)
+
+// NewMinos returns a deployment of Minos, configured by the config file at DefaultConfigPath
+// and the environment variables.
+func NewMinos(cluster string, userName string) (Minos, error) {
+	cfg, err := LoadConfig(DefaultConfigPath(), cluster)
+	if err != nil {
+		return nil, err
 	}
+	return NewMinosWithConfig(cluster, userName, cfg)
+}
 
-	orgIDEnvVal := os.Getenv("PEGASUS_TEAM_ORG_ID")
-	if orgIDEnvVal == "" {
-		panic("Please set the environment variable PEGASUS_TEAM_ORG_ID")
+// NewMinosWithConfig returns a deployment of Minos with the given settings.
+func NewMinosWithConfig(cluster string, userName string, cfg *Config) (Minos, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	d := &minosDeployment{
+		cluster:           cluster,
+		userName:          userName,
+		orgID:             cfg.OrgID,
+		minosAPIAddress:   strings.TrimSuffix(cfg.APIURL, "/"),
+		pegasusGatewayURL: strings.TrimSuffix(cfg.GatewayURL, "/"),
 	}
+
 	var err error
-	d.orgID, err = strconv.Atoi(orgIDEnvVal)
+	d.opTimeout, err = parseDurationOr("op_timeout", cfg.OpTimeout, defaultOpTimeout)
 	if err != nil {
-		panic(fmt.Sprintf("PEGASUS_TEAM_ORG_ID is not a valid integer: \"%s\"", orgIDEnvVal))
+		return nil, err
+	}
+	requestTimeout, err := parseDurationOr("request_timeout", cfg.RequestTimeout, defaultRequestTimeout)
+	if err != nil {
+		return nil, err
 	}
 
-	d.pegasusGatewayURL = os.Getenv("PEGASUS_GATEWAY_URL")
-	if d.pegasusGatewayURL == "" {
-		panic("Please set the environment variable PEGASUS_GATEWAY_URL")
+	d.retryableErrorCodes = map[int]bool{}
+	for _, code := range cfg.RetryableErrorCodes {
+		d.retryableErrorCodes[code] = true
 	}
 
-	d.client = resty.New()
-	return d
+	d.client = resty.New().SetTimeout(requestTimeout)
+	if cfg.TokenFile != "" {
+		token, err := readToken(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		d.client.SetAuthToken(token)
+	}
+	return d, nil
 }
 
 type minosOpRetVal struct {
 	ErrorMsg  string `json:"error_msg"`
 	ErrorCode int    `json:"error_code"`
+
+	// The ID of the asynchronous operation, which is absent in the elder versions of minos.
+	OperationID int `json:"operation_id"`
 }
 
 type minosOpResponse struct {
@@ -89,18 +164,38 @@ type minosOpResponse struct {
 // So we use a generic function for them all.
 func (m *minosDeployment) performGenericMinosOp(opType string, node deployment.Node) error {
 	taskID, _ := strconv.Atoi(node.Name)
+	return m.BatchOp(opType, node.Job, []int{taskID}, BatchOptions{Concurrency: 1, UpdateOptions: m.update})
+}
+
+func (m *minosDeployment) SetUpdateOptions(update UpdateOptions) {
+	m.update = update
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
 
+func (m *minosDeployment) BatchOp(opType string, job deployment.JobType, taskIDs []int, opts BatchOptions) error {
+	if opts.SkipPackage && opts.PackageRevision != "" {
+		return fmt.Errorf("package revision %s is specified while the package is not updated", opts.PackageRevision)
+	}
 	reqBody := map[string]interface{}{
 		"action":    opType,
 		"user_name": m.userName,
 		"org_ids":   []int{m.orgID},
 		"job_list": map[string]interface{}{
-			node.Job.String(): []int{taskID},
+			job.String(): taskIDs,
 		},
-		"concurrency":    1,
-		"step":           0,
-		"update_package": 1,
-		"update_config":  1,
+		"concurrency":    opts.Concurrency,
+		"step":           opts.Step,
+		"update_package": boolToInt(!opts.SkipPackage),
+		"update_config":  boolToInt(!opts.SkipConfig),
+	}
+	if opts.PackageRevision != "" {
+		reqBody["package_revision"] = opts.PackageRevision
 	}
 
 	var results minosOpResponse
@@ -111,9 +206,79 @@ func (m *minosDeployment) performGenericMinosOp(opType string, node deployment.N
 		return err
 	}
 	if !results.Success {
-		return fmt.Errorf("code: %d, message: %s", results.Retval.ErrorCode, results.Retval.ErrorMsg)
+		return m.newOpError(results.Retval.ErrorCode, results.Retval.ErrorMsg)
+	}
+	if results.Retval.OperationID == 0 {
+		log.Warnf("minos_%s returns no operation ID, unable to wait for its completion", opType)
+		return nil
+	}
+	return m.waitMinosOp(opType, results.Retval.OperationID)
+}
+
+// The states of a minos operation.
+const (
+	minosOpRunning = "running"
+	minosOpSuccess = "success"
+	minosOpFailed  = "failed"
+)
+
+const defaultOpTimeout = 30 * time.Minute
+
+// the interval of polling the status of an operation, changeable in tests
+var opCheckInterval = 5 * time.Second
+
+type minosOpStatusRetVal struct {
+	ErrorMsg  string `json:"error_msg"`
+	ErrorCode int    `json:"error_code"`
+
+	Status string `json:"status"`
+	// the step-by-step logs of the operation, useful for diagnosing failures
+	Details []string `json:"details"`
+}
+
+type minosOpStatusResponse struct {
+	Retval  minosOpStatusRetVal `json:"retval"`
+	Success bool                `json:"success"`
+}
+
+// waitMinosOp polls the status of the asynchronous operation until it finishes.
+// Minos accepts an operation immediately, while the packages may still be downloading
+// and the process may fail to start later.
+func (m *minosDeployment) waitMinosOp(opType string, opID int) error {
+	deadline := time.Now().Add(m.opTimeout)
+	for {
+		var results minosOpStatusResponse
+		resp, err := m.client.R().
+			Get(fmt.Sprintf("%s/cloud_manager/pegasus-%s/operations/%d", m.minosAPIAddress, m.cluster, opID))
+		err = handleRestyResult("minos_query_operation", err, resp, &results)
+		if err == nil && !results.Success {
+			err = m.newOpError(results.Retval.ErrorCode, results.Retval.ErrorMsg)
+		}
+		if err != nil {
+			// The operation has been submitted, issuing it again on a transient failure
+			// of the query would operate the nodes twice. Keep polling instead.
+			if !deployment.IsRetryable(err) || time.Now().After(deadline) {
+				return fmt.Errorf("unable to query minos_%s operation %d: %s", opType, opID, err)
+			}
+			log.Warnf("unable to query minos_%s operation %d, retry later: %s", opType, opID, err)
+			time.Sleep(opCheckInterval)
+			continue
+		}
+
+		switch results.Retval.Status {
+		case minosOpSuccess:
+			return nil
+		case minosOpFailed:
+			return fmt.Errorf("minos_%s operation %d failed: %s\n%s", opType, opID,
+				results.Retval.ErrorMsg, strings.Join(results.Retval.Details, "\n"))
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("minos_%s operation %d is still %s after %s", opType, opID, results.Retval.Status, m.opTimeout)
+		}
+		log.Debugf("minos_%s operation %d is %s", opType, opID, results.Retval.Status)
+		time.Sleep(opCheckInterval)
 	}
-	return nil
 }
 
 func (m *minosDeployment) StartNode(node deployment.Node) error {
@@ -124,6 +289,10 @@ func (m *minosDeployment) StopNode(node deployment.Node) error {
 	return m.performGenericMinosOp("stop", node)
 }
 
+func (m *minosDeployment) RestartNode(node deployment.Node) error {
+	return m.performGenericMinosOp("restart", node)
+}
+
 func (m *minosDeployment) RollingUpdate(node deployment.Node) error {
 	return m.performGenericMinosOp("rolling_update", node)
 }
@@ -132,6 +301,11 @@ func (m *minosDeployment) ListAllNodes() ([]deployment.Node, error) {
 	type nodeDetails struct {
 		Job    string `json:"job"`
 		TaskID int    `json:"task_id"`
+
+		PackageRevision string `json:"package_revision"`
+		ConfigRevision  string `json:"config_revision"`
+		// the supervisor state of the process, e.g. RUNNING
+		Status string `json:"status"`
 	}
 	var results map[string]nodeDetails
 
@@ -142,32 +316,64 @@ func (m *minosDeployment) ListAllNodes() ([]deployment.Node, error) {
 
 	var allNodes []deployment.Node
 	for tcpAddr, n := range results {
-		var job deployment.JobType
-		switch n.Job {
-		case "replica":
-			job = deployment.JobReplica
-		case "collector":
-			job = deployment.JobCollector
-		case "meta":
-			job = deployment.JobMeta
+		job, err := deployment.ParseJobType(n.Job)
+		if err != nil {
+			return nil, fmt.Errorf("GatewayListEndpoints returns node %s of %s", tcpAddr, err)
+		}
+		node := deployment.NewNode(fmt.Sprint(n.TaskID), tcpAddr, job)
+		if n.PackageRevision != "" {
+			node.Attrs[AttrPackageRevision] = n.PackageRevision
 		}
-		allNodes = append(allNodes, deployment.NewNode(fmt.Sprint(n.TaskID), tcpAddr, job))
+		if n.ConfigRevision != "" {
+			node.Attrs[AttrConfigRevision] = n.ConfigRevision
+		}
+		if status := nodeStatus(n.Status); status != "" {
+			node.Attrs[deployment.AttrStatus] = status
+		}
+		allNodes = append(allNodes, node)
 	}
 	return allNodes, nil
 }
 
+// nodeStatus converts the supervisor state reported by the gateway to the node status
+// of deployment, or returns "" for the transitional or unknown states.
+func nodeStatus(state string) string {
+	switch strings.ToUpper(state) {
+	case "RUNNING":
+		return deployment.NodeStatusRunning
+	case "STOPPED", "EXITED", "FATAL":
+		return deployment.NodeStatusStopped
+	default:
+		return ""
+	}
+}
+
 func (m *minosDeployment) Name() string {
 	return "minos"
 }
 
+func (m *minosDeployment) newOpError(code int, msg string) error {
+	return &deployment.RetryableError{
+		Message:   fmt.Sprintf("code: %d, message: %s", code, msg),
+		Transient: m.retryableErrorCodes[code],
+	}
+}
+
 func handleRestyError(op string, err error, resp *resty.Response) error {
 	if err != nil {
 		return err
 	}
 	if !resp.IsSuccess() {
-		reqBytes, _ := httputil.DumpRequest(resp.Request.RawRequest, true)
+		// never print the credential
+		rawReq := *resp.Request.RawRequest
+		rawReq.Header = rawReq.Header.Clone()
+		rawReq.Header.Del("Authorization")
+		reqBytes, _ := httputil.DumpRequest(&rawReq, true)
 		reqBodyBytes, _ := json.MarshalIndent(resp.Request.Body, "", "  ")
-		return fmt.Errorf("%s failed: %s %s%s\n\nResponse: %s", op, resp.Status(), string(reqBytes), reqBodyBytes, resp.Body())
+		return &deployment.RetryableError{
+			Message:   fmt.Sprintf("%s failed: %s %s%s\n\nResponse: %s", op, resp.Status(), string(reqBytes), reqBodyBytes, resp.Body()),
+			Transient: deployment.IsRetryableStatus(resp.StatusCode()),
+		}
 	}
 	return nil
 }
@@ -0,0 +1,465 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package minos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// fakeMinos emulates the minos api service and the pegasus gateway.
+type fakeMinos struct {
+	t *testing.T
+
+	mu sync.Mutex
+	// the bodies of the received action requests
+	actions []map[string]interface{}
+	// the Authorization headers of all received requests
+	authHeaders []string
+
+	// the response of the action request
+	actionStatus int
+	actionResp   minosOpResponse
+	// the statuses returned by successive queries of the operation
+	opStatuses []minosOpStatusRetVal
+	// the HTTP statuses of successive queries of the operation before opStatuses are returned
+	opQueryFailures []int
+	// the response of the gateway
+	endpoints map[string]interface{}
+	// how long every request takes
+	delay time.Duration
+}
+
+func newFakeMinos(t *testing.T) (*fakeMinos, *httptest.Server) {
+	f := &fakeMinos{
+		t:            t,
+		actionStatus: http.StatusOK,
+		actionResp:   minosOpResponse{Success: true, Retval: minosOpRetVal{OperationID: 7}},
+		opStatuses:   []minosOpStatusRetVal{{Status: minosOpRunning}, {Status: minosOpSuccess}},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeMinos) serve(w http.ResponseWriter, r *http.Request) {
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authHeaders = append(f.authHeaders, r.Header.Get("Authorization"))
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/cloud_manager/pegasus-onebox":
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			f.t.Errorf("invalid action request: %s", err)
+		}
+		f.actions = append(f.actions, body)
+		w.WriteHeader(f.actionStatus)
+		_ = json.NewEncoder(w).Encode(f.actionResp)
+	case r.Method == http.MethodGet && r.URL.Path == "/cloud_manager/pegasus-onebox/operations/7":
+		if len(f.opQueryFailures) > 0 {
+			w.WriteHeader(f.opQueryFailures[0])
+			f.opQueryFailures = f.opQueryFailures[1:]
+			return
+		}
+		status := f.opStatuses[0]
+		if len(f.opStatuses) > 1 {
+			f.opStatuses = f.opStatuses[1:]
+		}
+		_ = json.NewEncoder(w).Encode(minosOpStatusResponse{Success: true, Retval: status})
+	case r.Method == http.MethodPost && r.URL.Path == "/endpoints" && r.URL.Query().Get("cluster") == "onebox":
+		_ = json.NewEncoder(w).Encode(f.endpoints)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func testConfig(srv *httptest.Server) *Config {
+	return &Config{
+		APIURL:     srv.URL,
+		OrgID:      1,
+		GatewayURL: srv.URL + "/",
+	}
+}
+
+func newTestMinos(t *testing.T, cfg *Config) Minos {
+	m, err := NewMinosWithConfig("onebox", "tester", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// tempDir creates a directory that is removed after the test.
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cluster-cli-minos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func init() {
+	opCheckInterval = time.Millisecond
+}
+
+func TestBatchOpWaitsForOperation(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	m := newTestMinos(t, testConfig(srv))
+
+	opts := BatchOptions{Concurrency: 2, Step: 1, UpdateOptions: UpdateOptions{SkipConfig: true, PackageRevision: "r42"}}
+	if err := m.BatchOp("rolling_update", deployment.JobReplica, []int{1, 2}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.opStatuses) != 1 || f.opStatuses[0].Status != minosOpSuccess {
+		t.Fatalf("the operation is not polled until success")
+	}
+	if len(f.actions) != 1 {
+		t.Fatalf("expect 1 action request, got %d", len(f.actions))
+	}
+	body := f.actions[0]
+	expected := map[string]interface{}{
+		"action":           "rolling_update",
+		"user_name":        "tester",
+		"concurrency":      float64(2),
+		"step":             float64(1),
+		"update_package":   float64(1),
+		"update_config":    float64(0),
+		"package_revision": "r42",
+	}
+	for k, v := range expected {
+		if body[k] != v {
+			t.Errorf("%s: expect %v, got %v", k, v, body[k])
+		}
+	}
+	jobList, _ := json.Marshal(body["job_list"])
+	if string(jobList) != `{"replica":[1,2]}` {
+		t.Errorf("unexpected job_list %s", jobList)
+	}
+}
+
+func TestSingleNodeOp(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	m := newTestMinos(t, testConfig(srv))
+	m.SetUpdateOptions(UpdateOptions{SkipPackage: true})
+
+	if err := m.RollingUpdate(deployment.Node{Name: "3", Job: deployment.JobMeta}); err != nil {
+		t.Fatal(err)
+	}
+	body := f.actions[0]
+	jobList, _ := json.Marshal(body["job_list"])
+	if string(jobList) != `{"meta":[3]}` || body["update_package"] != float64(0) || body["update_config"] != float64(1) {
+		t.Errorf("unexpected action request %v", body)
+	}
+}
+
+func TestBatchOpFailed(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	f.opStatuses = []minosOpStatusRetVal{{Status: minosOpFailed, ErrorMsg: "bad package", Details: []string{"downloading", "start failed"}}}
+	m := newTestMinos(t, testConfig(srv))
+
+	err := m.StartNode(deployment.Node{Name: "1", Job: deployment.JobReplica})
+	if err == nil || !strings.Contains(err.Error(), "bad package") || !strings.Contains(err.Error(), "start failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBatchOpTimeout(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	f.opStatuses = []minosOpStatusRetVal{{Status: minosOpRunning}}
+	cfg := testConfig(srv)
+	cfg.OpTimeout = "20ms"
+	m := newTestMinos(t, cfg)
+
+	err := m.StopNode(deployment.Node{Name: "1", Job: deployment.JobReplica})
+	if err == nil || !strings.Contains(err.Error(), "still running") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBatchOpQueryFailed(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  []int
+		opTimeout string
+		// the expected error, empty means success
+		err string
+	}{
+		{name: "transient", failures: []int{http.StatusBadGateway, http.StatusServiceUnavailable}},
+		{name: "fatal", failures: []int{http.StatusForbidden}, err: "403"},
+		{name: "timeout", failures: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			opTimeout: "1ns", err: "502"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, srv := newFakeMinos(t)
+			f.opQueryFailures = tt.failures
+			cfg := testConfig(srv)
+			cfg.OpTimeout = tt.opTimeout
+			m := newTestMinos(t, cfg)
+
+			err := m.RollingUpdate(deployment.Node{Name: "1", Job: deployment.JobReplica})
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err) || deployment.IsRetryable(err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// the submitted operation is never issued again
+			if len(f.actions) != 1 {
+				t.Fatalf("expect 1 action request, got %d", len(f.actions))
+			}
+		})
+	}
+}
+
+func TestBatchOpWithoutOperationID(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	f.actionResp.Retval.OperationID = 0
+	m := newTestMinos(t, testConfig(srv))
+
+	if err := m.StopNode(deployment.Node{Name: "1", Job: deployment.JobReplica}); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.opStatuses) != 2 {
+		t.Fatal("the operation should not be polled without an ID")
+	}
+}
+
+func TestRetryableErrors(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	cfg := testConfig(srv)
+	cfg.RetryableErrorCodes = []int{1001}
+	m := newTestMinos(t, cfg)
+	node := deployment.Node{Name: "1", Job: deployment.JobReplica}
+
+	f.actionStatus = http.StatusServiceUnavailable
+	if err := m.StartNode(node); !deployment.IsRetryable(err) {
+		t.Errorf("HTTP 503 should be retryable: %v", err)
+	}
+	f.actionStatus = http.StatusBadRequest
+	if err := m.StartNode(node); err == nil || deployment.IsRetryable(err) {
+		t.Errorf("HTTP 400 should not be retryable: %v", err)
+	}
+
+	f.actionStatus = http.StatusOK
+	f.actionResp = minosOpResponse{Success: false, Retval: minosOpRetVal{ErrorCode: 1001, ErrorMsg: "busy"}}
+	if err := m.StartNode(node); !deployment.IsRetryable(err) {
+		t.Errorf("error code 1001 should be retryable: %v", err)
+	}
+	f.actionResp.Retval.ErrorCode = 1002
+	if err := m.StartNode(node); err == nil || deployment.IsRetryable(err) {
+		t.Errorf("error code 1002 should not be retryable: %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	f.delay = 200 * time.Millisecond
+	cfg := testConfig(srv)
+	cfg.RequestTimeout = "20ms"
+	m := newTestMinos(t, cfg)
+
+	if err := m.StartNode(deployment.Node{Name: "1", Job: deployment.JobReplica}); err == nil {
+		t.Fatal("the request should time out")
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	cfg := testConfig(srv)
+	cfg.TokenFile = filepath.Join(tempDir(t), "token")
+	if err := ioutil.WriteFile(cfg.TokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	m := newTestMinos(t, cfg)
+
+	if err := m.StartNode(deployment.Node{Name: "1", Job: deployment.JobReplica}); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range f.authHeaders {
+		if h != "Bearer secret" {
+			t.Errorf("unexpected Authorization header %q", h)
+		}
+	}
+
+	// the credential is not leaked in errors
+	f.actionStatus = http.StatusInternalServerError
+	err := m.StartNode(deployment.Node{Name: "1", Job: deployment.JobReplica})
+	if err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestListAllNodes(t *testing.T) {
+	f, srv := newFakeMinos(t)
+	f.endpoints = map[string]interface{}{
+		"127.0.0.1:34601": map[string]interface{}{"job": "meta", "task_id": 0},
+		"127.0.0.1:34801": map[string]interface{}{"job": "replica", "task_id": 1, "package_revision": "r42", "config_revision": "c7",
+			"status": "RUNNING"},
+		"127.0.0.1:34802": map[string]interface{}{"job": "replica", "task_id": 2, "status": "EXITED"},
+	}
+	m := newTestMinos(t, testConfig(srv))
+
+	nodes, err := m.ListAllNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 3 {
+		t.Fatalf("expect 3 nodes, got %d", len(nodes))
+	}
+	for _, n := range nodes {
+		switch n.IPPort {
+		case "127.0.0.1:34601":
+			if n.Job != deployment.JobMeta || n.Name != "0" || len(n.Attrs) != 0 {
+				t.Errorf("unexpected node %+v", n)
+			}
+		case "127.0.0.1:34801":
+			if n.Job != deployment.JobReplica || n.Name != "1" ||
+				n.Attrs[AttrPackageRevision] != "r42" || n.Attrs[AttrConfigRevision] != "c7" ||
+				n.Attrs[deployment.AttrStatus] != deployment.NodeStatusRunning {
+				t.Errorf("unexpected node %+v", n)
+			}
+		case "127.0.0.1:34802":
+			if n.Name != "2" || n.Attrs[deployment.AttrStatus] != deployment.NodeStatusStopped {
+				t.Errorf("unexpected node %+v", n)
+			}
+		default:
+			t.Errorf("unexpected node %+v", n)
+		}
+	}
+}
+
+func TestNewMinosWithInvalidConfig(t *testing.T) {
+	valid := Config{APIURL: "http://minos", OrgID: 1, GatewayURL: "http://gateway"}
+	for _, modify := range []func(*Config){
+		func(c *Config) { c.APIURL = "" },
+		func(c *Config) { c.OrgID = 0 },
+		func(c *Config) { c.GatewayURL = "" },
+		func(c *Config) { c.OpTimeout = "1 hour" },
+		func(c *Config) { c.RequestTimeout = "abc" },
+		func(c *Config) { c.TokenFile = "/not/exist/token" },
+	} {
+		cfg := valid
+		modify(&cfg)
+		if _, err := NewMinosWithConfig("onebox", "", &cfg); err == nil {
+			t.Errorf("expect error for config %+v", cfg)
+		}
+	}
+	if _, err := NewMinosWithConfig("onebox", "", &valid); err != nil {
+		t.Error(err)
+	}
+}
+
+// setEnv sets the minos environment variables for the test, and unsets the others.
+func setEnv(t *testing.T, env map[string]string) {
+	for _, key := range []string{"MINOS_API_URL", "PEGASUS_TEAM_ORG_ID", "PEGASUS_GATEWAY_URL",
+		"MINOS_TOKEN_FILE", "MINOS_REQUEST_TIMEOUT", "MINOS_OP_TIMEOUT", "MINOS_RETRYABLE_ERROR_CODES"} {
+		old, ok := os.LookupEnv(key)
+		if val, set := env[key]; set {
+			os.Setenv(key, val)
+		} else {
+			os.Unsetenv(key)
+		}
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(tempDir(t), "minos.json")
+	content := `{
+  "default": {"api_url": "http://minos", "org_id": 1, "gateway_url": "http://gateway", "op_timeout": "1h"},
+  "clusters": {
+    "onebox": {"api_url": "http://minos-staging", "token_file": "/etc/minos/token", "retryable_error_codes": [1001]}
+  }
+}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	setEnv(t, nil)
+	cfg, err := LoadConfig(path, "onebox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIURL != "http://minos-staging" || cfg.OrgID != 1 || cfg.GatewayURL != "http://gateway" ||
+		cfg.OpTimeout != "1h" || cfg.TokenFile != "/etc/minos/token" ||
+		len(cfg.RetryableErrorCodes) != 1 || cfg.RetryableErrorCodes[0] != 1001 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	cfg, err = LoadConfig(path, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIURL != "http://minos" || cfg.TokenFile != "" || cfg.RetryableErrorCodes != nil {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	// the environment variables override the file
+	setEnv(t, map[string]string{"MINOS_API_URL": "http://minos-env", "PEGASUS_TEAM_ORG_ID": "2", "MINOS_RETRYABLE_ERROR_CODES": "1, 2"})
+	cfg, err = LoadConfig(path, "onebox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.APIURL != "http://minos-env" || cfg.OrgID != 2 || len(cfg.RetryableErrorCodes) != 2 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	setEnv(t, map[string]string{"PEGASUS_TEAM_ORG_ID": "pegasus"})
+	if _, err := LoadConfig(path, "onebox"); err == nil {
+		t.Error("expect error for invalid PEGASUS_TEAM_ORG_ID")
+	}
+}
+
+func TestLoadConfigWithoutFile(t *testing.T) {
+	setEnv(t, map[string]string{"MINOS_API_URL": "http://minos", "PEGASUS_TEAM_ORG_ID": "1", "PEGASUS_GATEWAY_URL": "http://gateway"})
+	cfg, err := LoadConfig(filepath.Join(tempDir(t), "minos.json"), "onebox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Error(err)
+	}
+
+	path := filepath.Join(tempDir(t), "minos.json")
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path, "onebox"); err == nil {
+		t.Error("expect error for malformed config file")
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package onebox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// Config describes a onebox cluster on the local machine, for example:
+//
+//	{
+//	  "binary": "/home/work/pegasus/DSN_ROOT/bin/pegasus_server/pegasus_server",
+//	  "work_dir": "/home/work/onebox",
+//	  "nodes": [
+//	    {"name": "meta1", "job": "meta", "ip_port": "127.0.0.1:34601", "config_dir": "meta1"},
+//	    {"name": "replica1", "job": "replica", "ip_port": "127.0.0.1:34801", "config_dir": "replica1"},
+//	    {"name": "collector", "job": "collector", "ip_port": "127.0.0.1:34101", "config_dir": "collector"}
+//	  ]
+//	}
+//
+// A node is launched as "<binary> config.ini -app_list <job>" in its config dir, which must
+// contain config.ini. To rolling-update the nodes, point "binary" to the new build.
+type Config struct {
+	// The pegasus_server binary.
+	Binary string `json:"binary"`
+
+	// The directory of the state file and the logs of the processes.
+	WorkDir string `json:"work_dir"`
+
+	Nodes []NodeConfig `json:"nodes"`
+}
+
+// NodeConfig is a node of the onebox cluster.
+type NodeConfig struct {
+	Name string `json:"name"`
+
+	// "meta", "replica" or "collector"
+	Job string `json:"job"`
+
+	IPPort string `json:"ip_port"`
+
+	// The working directory of the process, relative to WorkDir if not absolute.
+	ConfigDir string `json:"config_dir"`
+
+	// The arguments of the binary, ["config.ini", "-app_list", "<job>"] if empty.
+	Args []string `json:"args,omitempty"`
+}
+
+// LoadConfig reads the config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid onebox config file %s: %s", path, err)
+	}
+	return &cfg, nil
+}
+
+func (c *Config) validate() error {
+	if c.Binary == "" {
+		return fmt.Errorf("onebox binary is not configured")
+	}
+	if c.WorkDir == "" {
+		return fmt.Errorf("onebox work_dir is not configured")
+	}
+	names := map[string]bool{}
+	for _, n := range c.Nodes {
+		if n.Name == "" || n.IPPort == "" || n.ConfigDir == "" {
+			return fmt.Errorf("onebox node %+v: name, ip_port and config_dir are required", n)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("onebox node %s is duplicated", n.Name)
+		}
+		names[n.Name] = true
+		if _, err := deployment.ParseJobType(n.Job); err != nil {
+			return fmt.Errorf("onebox node %s: %s", n.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) configDir(n *NodeConfig) string {
+	if filepath.IsAbs(n.ConfigDir) {
+		return n.ConfigDir
+	}
+	return filepath.Join(c.WorkDir, n.ConfigDir)
+}
@@ -0,0 +1,294 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package onebox implements a Deployment that runs all nodes of a cluster as local
+// processes, which is handy for development and demos.
+package onebox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	log "github.com/sirupsen/logrus"
+)
+
+// Changeable in tests.
+var (
+	// a process that exits within this duration after launch is considered failed to start
+	startCheckDelay = 2 * time.Second
+
+	// the process is killed if it doesn't exit within this duration after SIGTERM
+	stopTimeout = 30 * time.Second
+)
+
+// nodeState is a node and the process running it, which is persisted in the state file.
+type nodeState struct {
+	NodeConfig
+
+	// 0 if the node is not started
+	PID int `json:"pid,omitempty"`
+
+	// the binary that the process was launched from
+	Binary string `json:"binary,omitempty"`
+}
+
+type oneboxDeployment struct {
+	cluster string
+	cfg     *Config
+
+	mu sync.Mutex
+}
+
+// New returns a Deployment of the onebox cluster. The nodes in the config are merged
+// into the state file, the processes that were launched before are kept tracking.
+func New(cluster string, cfg *Config) (deployment.Deployment, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.WorkDir, 0755); err != nil {
+		return nil, err
+	}
+	d := &oneboxDeployment{cluster: cluster, cfg: cfg}
+
+	old, err := d.loadState()
+	if err != nil {
+		return nil, err
+	}
+	oldByName := map[string]*nodeState{}
+	for _, n := range old {
+		oldByName[n.Name] = n
+	}
+	var states []*nodeState
+	for _, n := range cfg.Nodes {
+		s := &nodeState{NodeConfig: n}
+		s.ConfigDir = cfg.configDir(&n)
+		if o, ok := oldByName[n.Name]; ok {
+			s.PID, s.Binary = o.PID, o.Binary
+		}
+		states = append(states, s)
+	}
+	if err := d.saveState(states); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *oneboxDeployment) statePath() string {
+	return filepath.Join(d.cfg.WorkDir, "state.json")
+}
+
+func (d *oneboxDeployment) loadState() ([]*nodeState, error) {
+	data, err := ioutil.ReadFile(d.statePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var states []*nodeState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return nil, fmt.Errorf("corrupted onebox state file %s: %s", d.statePath(), err)
+	}
+	return states, nil
+}
+
+func (d *oneboxDeployment) saveState(states []*nodeState) error {
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.statePath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.statePath())
+}
+
+// operate runs the action on the state of the node, and persists the state.
+func (d *oneboxDeployment) operate(name string, action func(*nodeState) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	states, err := d.loadState()
+	if err != nil {
+		return err
+	}
+	var target *nodeState
+	for _, s := range states {
+		if s.Name == name {
+			target = s
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("node %s is not found in onebox", name)
+	}
+	err = action(target)
+	if saveErr := d.saveState(states); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+func isAlive(pid int) bool {
+	return pid > 0 && syscall.Kill(pid, 0) == nil
+}
+
+// spawn launches the process of the node from the binary.
+func (d *oneboxDeployment) spawn(s *nodeState, binary string) error {
+	args := s.Args
+	if len(args) == 0 {
+		args = []string{"config.ini", "-app_list", s.Job}
+	}
+	logPath := filepath.Join(d.cfg.WorkDir, s.Name+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(binary, args...)
+	cmd.Dir = s.ConfigDir
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// not killed together with pegasus-cluster-cli by Ctrl-C
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to launch node %s: %s", s.Name, err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		// reap the process if it exits before pegasus-cluster-cli
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		return fmt.Errorf("node %s exited right after launch (%v), see %s", s.Name, err, logPath)
+	case <-time.After(startCheckDelay):
+	}
+	s.PID = cmd.Process.Pid
+	s.Binary = binary
+	log.Printf("launched node %s from %s, pid %d", s.Name, binary, s.PID)
+	return nil
+}
+
+// kill terminates the process of the node gracefully, or forcibly after stopTimeout.
+func (d *oneboxDeployment) kill(s *nodeState) error {
+	if !isAlive(s.PID) {
+		s.PID = 0
+		return nil
+	}
+	if err := syscall.Kill(s.PID, syscall.SIGTERM); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for isAlive(s.PID) {
+		if time.Now().After(deadline) {
+			log.Warnf("node %s (pid %d) doesn't exit after %s, killing it", s.Name, s.PID, stopTimeout)
+			_ = syscall.Kill(s.PID, syscall.SIGKILL)
+			deadline = time.Now().Add(stopTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	log.Printf("stopped node %s (pid %d)", s.Name, s.PID)
+	s.PID = 0
+	return nil
+}
+
+func (d *oneboxDeployment) StartNode(node deployment.Node) error {
+	return d.operate(node.Name, func(s *nodeState) error {
+		if isAlive(s.PID) {
+			log.Printf("node %s is already running, pid %d", s.Name, s.PID)
+			return nil
+		}
+		s.PID = 0
+		return d.spawn(s, d.cfg.Binary)
+	})
+}
+
+func (d *oneboxDeployment) StopNode(node deployment.Node) error {
+	return d.operate(node.Name, d.kill)
+}
+
+// RestartNode restarts the node with the binary it's running.
+func (d *oneboxDeployment) RestartNode(node deployment.Node) error {
+	return d.operate(node.Name, func(s *nodeState) error {
+		binary := s.Binary
+		if binary == "" {
+			binary = d.cfg.Binary
+		}
+		if err := d.kill(s); err != nil {
+			return err
+		}
+		return d.spawn(s, binary)
+	})
+}
+
+// RollingUpdate restarts the node with the binary in the config.
+func (d *oneboxDeployment) RollingUpdate(node deployment.Node) error {
+	return d.operate(node.Name, func(s *nodeState) error {
+		if err := d.kill(s); err != nil {
+			return err
+		}
+		return d.spawn(s, d.cfg.Binary)
+	})
+}
+
+// The attributes of a onebox node.
+const (
+	AttrPID    = "PID"
+	AttrBinary = "Binary"
+)
+
+func (d *oneboxDeployment) ListAllNodes() ([]deployment.Node, error) {
+	d.mu.Lock()
+	states, err := d.loadState()
+	d.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []deployment.Node
+	for _, s := range states {
+		job, err := deployment.ParseJobType(s.Job)
+		if err != nil {
+			return nil, err
+		}
+		node := deployment.NewNode(s.Name, s.IPPort, job)
+		if isAlive(s.PID) {
+			node.Attrs[deployment.AttrStatus] = deployment.NodeStatusRunning
+			node.Attrs[AttrPID] = s.PID
+			node.Attrs[AttrBinary] = s.Binary
+		} else {
+			node.Attrs[deployment.AttrStatus] = deployment.NodeStatusStopped
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (d *oneboxDeployment) Name() string {
+	return "onebox"
+}
@@ -0,0 +1,211 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package onebox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+func init() {
+	startCheckDelay = 100 * time.Millisecond
+	stopTimeout = time.Second
+}
+
+// writeScript writes a fake pegasus_server that records its arguments and sleeps.
+func writeScript(t *testing.T, dir string, name string, body string) string {
+	path := filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\necho \"$0 $@\"\n%s\n", body)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestOnebox(t *testing.T) (*Config, deployment.Deployment) {
+	workDir, err := ioutil.TempDir("", "cluster-cli-onebox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// removed after the nodes are stopped
+	t.Cleanup(func() { os.RemoveAll(workDir) })
+	for _, dir := range []string{"meta1", "replica1"} {
+		if err := os.Mkdir(filepath.Join(workDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &Config{
+		Binary:  writeScript(t, workDir, "server_v1", "exec sleep 60"),
+		WorkDir: workDir,
+		Nodes: []NodeConfig{
+			{Name: "meta1", Job: "meta", IPPort: "127.0.0.1:34601", ConfigDir: "meta1"},
+			{Name: "replica1", Job: "replica", IPPort: "127.0.0.1:34801", ConfigDir: "replica1"},
+		},
+	}
+	d, err := New("onebox", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, n := range cfg.Nodes {
+			_ = d.StopNode(deployment.Node{Name: n.Name})
+		}
+	})
+	return cfg, d
+}
+
+func listNodes(t *testing.T, d deployment.Deployment) map[string]deployment.Node {
+	nodes, err := d.ListAllNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]deployment.Node{}
+	for _, n := range nodes {
+		m[n.Name] = n
+	}
+	return m
+}
+
+func TestStartStopNode(t *testing.T) {
+	cfg, d := newTestOnebox(t)
+	replica := deployment.Node{Name: "replica1", Job: deployment.JobReplica}
+
+	nodes := listNodes(t, d)
+	if len(nodes) != 2 || nodes["meta1"].Job != deployment.JobMeta || nodes["replica1"].IPPort != "127.0.0.1:34801" {
+		t.Fatalf("unexpected nodes %+v", nodes)
+	}
+	if nodes["replica1"].Attrs[deployment.AttrStatus] != deployment.NodeStatusStopped {
+		t.Errorf("replica1 should be stopped: %+v", nodes["replica1"])
+	}
+
+	if err := d.StartNode(replica); err != nil {
+		t.Fatal(err)
+	}
+	nodes = listNodes(t, d)
+	pid := nodes["replica1"].Attrs[AttrPID]
+	if nodes["replica1"].Attrs[deployment.AttrStatus] != deployment.NodeStatusRunning || pid == nil {
+		t.Fatalf("replica1 should be running: %+v", nodes["replica1"])
+	}
+	// starting a running node is a no-op
+	if err := d.StartNode(replica); err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d)["replica1"].Attrs[AttrPID] != pid {
+		t.Error("replica1 should not be relaunched")
+	}
+
+	logData, _ := ioutil.ReadFile(filepath.Join(cfg.WorkDir, "replica1.log"))
+	if !strings.Contains(string(logData), "config.ini -app_list replica") {
+		t.Errorf("unexpected arguments: %s", logData)
+	}
+
+	// the state survives another instance of the deployment
+	d2, err := New("onebox", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d2)["replica1"].Attrs[AttrPID] != pid {
+		t.Error("the process of replica1 is not tracked")
+	}
+
+	if err := d2.StopNode(replica); err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d)["replica1"].Attrs[deployment.AttrStatus] != deployment.NodeStatusStopped {
+		t.Error("replica1 should be stopped")
+	}
+}
+
+func TestRollingUpdate(t *testing.T) {
+	cfg, d := newTestOnebox(t)
+	meta := deployment.Node{Name: "meta1", Job: deployment.JobMeta}
+	if err := d.StartNode(meta); err != nil {
+		t.Fatal(err)
+	}
+	oldBinary := cfg.Binary
+
+	cfg.Binary = writeScript(t, cfg.WorkDir, "server_v2", "exec sleep 60")
+	if err := deployment.RestartNode(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d)["meta1"].Attrs[AttrBinary] != oldBinary {
+		t.Error("restart should keep the binary")
+	}
+
+	if err := d.RollingUpdate(meta); err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d)["meta1"].Attrs[AttrBinary] != cfg.Binary {
+		t.Error("rolling update should switch to the new binary")
+	}
+}
+
+func TestStartFailure(t *testing.T) {
+	cfg, d := newTestOnebox(t)
+	cfg.Binary = writeScript(t, cfg.WorkDir, "server_broken", "exit 1")
+
+	err := d.StartNode(deployment.Node{Name: "meta1", Job: deployment.JobMeta})
+	if err == nil || !strings.Contains(err.Error(), "exited right after launch") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if listNodes(t, d)["meta1"].Attrs[deployment.AttrStatus] != deployment.NodeStatusStopped {
+		t.Error("meta1 should be stopped")
+	}
+	if err := d.StartNode(deployment.Node{Name: "meta2", Job: deployment.JobMeta}); err == nil {
+		t.Error("expect error for unknown node")
+	}
+}
+
+func TestStopUnresponsiveNode(t *testing.T) {
+	cfg, d := newTestOnebox(t)
+	cfg.Binary = writeScript(t, cfg.WorkDir, "server_stubborn", "trap '' TERM\nwhile true; do sleep 0.1; done")
+	replica := deployment.Node{Name: "replica1", Job: deployment.JobReplica}
+	if err := d.StartNode(replica); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.StopNode(replica); err != nil {
+		t.Fatal(err)
+	}
+	if listNodes(t, d)["replica1"].Attrs[deployment.AttrStatus] != deployment.NodeStatusStopped {
+		t.Error("replica1 should be killed")
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{WorkDir: "/tmp"},
+		{Binary: "pegasus_server"},
+		{Binary: "pegasus_server", WorkDir: "/tmp", Nodes: []NodeConfig{{Name: "m", Job: "master", IPPort: "127.0.0.1:34601", ConfigDir: "m"}}},
+		{Binary: "pegasus_server", WorkDir: "/tmp", Nodes: []NodeConfig{{Name: "m", Job: "meta", ConfigDir: "m"}}},
+		{Binary: "pegasus_server", WorkDir: "/tmp", Nodes: []NodeConfig{
+			{Name: "m", Job: "meta", IPPort: "127.0.0.1:34601", ConfigDir: "m"},
+			{Name: "m", Job: "meta", IPPort: "127.0.0.1:34602", ConfigDir: "m2"},
+		}},
+	} {
+		if _, err := New("onebox", &cfg); err == nil {
+			t.Errorf("expect error for config %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package plugin implements a Deployment that forwards the calls to an external executable,
+// so that any program, like an Ansible playbook wrapper or an in-house script, can act as a
+// deployment backend.
+//
+// For each call, the executable is launched with a JSON Request on its stdin, and is expected
+// to print a JSON Response to its stdout and exit. Before any operation, the plugin is asked
+// for its "capabilities", the plugin must support the operations in requiredOps.
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout is how long a call to the plugin can take by default.
+const DefaultTimeout = 30 * time.Minute
+
+// HandshakeTimeout is how long the plugin can take to report its capabilities, which is
+// independent of the timeout of operations.
+var HandshakeTimeout = time.Minute
+
+type pluginDeployment struct {
+	cluster string
+
+	// the executable and its arguments
+	path string
+	args []string
+
+	timeout time.Duration
+
+	name       string
+	operations map[string]bool
+}
+
+// New returns a Deployment of the cluster that is backed by the executable at path.
+// The plugin is asked for its capabilities here.
+func New(cluster string, path string, args []string, timeout time.Duration) (deployment.Deployment, error) {
+	d := &pluginDeployment{
+		cluster: cluster,
+		path:    path,
+		args:    args,
+		timeout: timeout,
+	}
+	resp, err := d.callWithTimeout(OpCapabilities, nil, HandshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	d.name = resp.Name
+	if d.name == "" {
+		d.name = "plugin"
+	}
+	d.operations = map[string]bool{}
+	for _, op := range resp.Operations {
+		d.operations[op] = true
+	}
+	for _, op := range requiredOps {
+		if !d.operations[op] {
+			return nil, fmt.Errorf("plugin %s doesn't support the required operation \"%s\"", path, op)
+		}
+	}
+	if d.operations[OpRestartNode] {
+		return &restartableDeployment{d}, nil
+	}
+	return d, nil
+}
+
+func (d *pluginDeployment) call(op string, node *deployment.Node) (*Response, error) {
+	return d.callWithTimeout(op, node, d.timeout)
+}
+
+func (d *pluginDeployment) callWithTimeout(op string, node *deployment.Node, timeout time.Duration) (*Response, error) {
+	req := Request{
+		Version:   ProtocolVersion,
+		Operation: op,
+		Cluster:   d.cluster,
+	}
+	if node != nil {
+		req.Node = toWireNode(*node)
+	}
+	reqBytes, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(d.path, d.args...)
+	cmd.Stdin = bytes.NewReader(reqBytes)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	// the logs of the plugin
+	cmd.Stderr = os.Stderr
+	// The plugin may be a script that runs the actual work in its children, which must be
+	// killed together on timeout, otherwise they keep the stdout open and the call hangs.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	log.Debugf("calling plugin %s: %s", d.path, reqBytes)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("plugin %s failed on \"%s\": %s", d.path, op, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-time.After(timeout):
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return nil, &deployment.RetryableError{Message: fmt.Sprintf("plugin %s timed out after %s on \"%s\"", d.path, timeout, op)}
+	}
+
+	var resp Response
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		if runErr != nil {
+			return nil, fmt.Errorf("plugin %s failed on \"%s\": %s", d.path, op, runErr)
+		}
+		return nil, fmt.Errorf("plugin %s returns an invalid response on \"%s\": %s\n%s", d.path, op, err, stdout.Bytes())
+	}
+	if resp.Version != ProtocolVersion {
+		return nil, fmt.Errorf("plugin %s speaks protocol version %d, while version %d is required",
+			d.path, resp.Version, ProtocolVersion)
+	}
+	if !resp.Success {
+		return nil, &deployment.RetryableError{
+			Message:   fmt.Sprintf("plugin %s failed on \"%s\": %s", d.path, op, resp.Error),
+			Transient: resp.Retryable,
+		}
+	}
+	if runErr != nil {
+		return nil, fmt.Errorf("plugin %s exits abnormally on \"%s\": %s", d.path, op, runErr)
+	}
+	return &resp, nil
+}
+
+func (d *pluginDeployment) StartNode(node deployment.Node) error {
+	_, err := d.call(OpStartNode, &node)
+	return err
+}
+
+func (d *pluginDeployment) StopNode(node deployment.Node) error {
+	_, err := d.call(OpStopNode, &node)
+	return err
+}
+
+func (d *pluginDeployment) RollingUpdate(node deployment.Node) error {
+	_, err := d.call(OpRollingUpdate, &node)
+	return err
+}
+
+// restartableDeployment is a deployment.Restarter, for the plugin that supports restart_node.
+type restartableDeployment struct {
+	*pluginDeployment
+}
+
+func (d *restartableDeployment) RestartNode(node deployment.Node) error {
+	_, err := d.call(OpRestartNode, &node)
+	return err
+}
+
+func (d *pluginDeployment) ListAllNodes() ([]deployment.Node, error) {
+	resp, err := d.call(OpListNodes, nil)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []deployment.Node
+	for _, n := range resp.Nodes {
+		job, err := deployment.ParseJobType(n.Job)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s returns node %s of %s", d.path, n.Name, err)
+		}
+		node := deployment.NewNode(n.Name, n.IPPort, job)
+		if n.Hostname != "" {
+			node.Hostname = n.Hostname
+		}
+		for k, v := range n.Attrs {
+			node.Attrs[k] = v
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (d *pluginDeployment) Name() string {
+	return d.name
+}
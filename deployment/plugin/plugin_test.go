@@ -0,0 +1,209 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// The test binary acts as the plugin when the environment variable is set, whose value
+// decides how the fake plugin behaves.
+const fakePluginEnv = "FAKE_DEPLOYMENT_PLUGIN"
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(fakePluginEnv); mode != "" {
+		os.Exit(runFakePlugin(mode))
+	}
+	os.Exit(m.Run())
+}
+
+func runFakePlugin(mode string) int {
+	var req Request
+	if err := json.NewDecoder(os.Stdin).Decode(&req); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp := Response{Version: ProtocolVersion, Success: true}
+	switch {
+	case mode == "old-version":
+		resp.Version = 0
+	case mode == "crash":
+		return 2
+	case mode == "hang" && req.Operation != OpCapabilities:
+		time.Sleep(time.Minute)
+	case mode == "hang-child" && req.Operation != OpCapabilities:
+		// the child inherits the stdout, and hangs as well
+		child := exec.Command(os.Args[0])
+		child.Env = append(os.Environ(), fakePluginEnv+"=hang")
+		child.Stdin = strings.NewReader(`{"operation": "stop_node"}`)
+		child.Stdout = os.Stdout
+		if err := child.Start(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		time.Sleep(time.Minute)
+	case req.Operation == OpCapabilities:
+		resp.Name = "fake"
+		resp.Operations = []string{OpStartNode, OpStopNode, OpRollingUpdate, OpListNodes}
+		if mode == "incapable" {
+			resp.Operations = resp.Operations[1:]
+		}
+		if mode == "restartable" {
+			resp.Operations = append(resp.Operations, OpRestartNode)
+		}
+	case req.Operation == OpListNodes:
+		resp.Nodes = []Node{
+			{Job: "meta", Name: "m0", IPPort: "127.0.0.1:34601", Attrs: map[string]interface{}{"Status": "Running"}},
+			{Job: "replica", Name: req.Cluster + "-r1", IPPort: "127.0.0.1:34801"},
+		}
+	case req.Node == nil:
+		resp.Success = false
+		resp.Error = "node is missing"
+	case req.Node.Job != "replica" || req.Node.Name != "r1":
+		resp.Success = false
+		resp.Error = fmt.Sprintf("unexpected node %+v", req.Node)
+	case mode == "busy":
+		resp.Success = false
+		resp.Error = "deployer is busy"
+		resp.Retryable = true
+	}
+	_ = json.NewEncoder(os.Stdout).Encode(resp)
+	return 0
+}
+
+func newFakePlugin(t *testing.T, mode string, timeout time.Duration) (deployment.Deployment, error) {
+	os.Setenv(fakePluginEnv, mode)
+	t.Cleanup(func() { os.Unsetenv(fakePluginEnv) })
+	return New("onebox", os.Args[0], nil, timeout)
+}
+
+func TestPluginRestartNode(t *testing.T) {
+	d, err := newFakePlugin(t, "restartable", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := d.(deployment.Restarter)
+	if !ok {
+		t.Fatal("the plugin with restart_node should be a Restarter")
+	}
+	if err := r.RestartNode(deployment.Node{Name: "r1", Job: deployment.JobReplica}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginOperations(t *testing.T) {
+	d, err := newFakePlugin(t, "normal", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name() != "fake" {
+		t.Errorf("unexpected name %s", d.Name())
+	}
+
+	node := deployment.Node{Name: "r1", Job: deployment.JobReplica, IPPort: "127.0.0.1:34801"}
+	if err := d.StartNode(node); err != nil {
+		t.Error(err)
+	}
+	if err := d.StopNode(node); err != nil {
+		t.Error(err)
+	}
+	if err := d.RollingUpdate(node); err != nil {
+		t.Error(err)
+	}
+	// restart_node is unsupported, the node is stopped and started instead
+	if _, ok := d.(deployment.Restarter); ok {
+		t.Error("the plugin without restart_node should not be a Restarter")
+	}
+	if err := deployment.RestartNode(d, node); err != nil {
+		t.Error(err)
+	}
+	err = d.StartNode(deployment.Node{Name: "r2", Job: deployment.JobReplica})
+	if err == nil || !strings.Contains(err.Error(), "unexpected node") || deployment.IsRetryable(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	nodes, err := d.ListAllNodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("expect 2 nodes, got %d", len(nodes))
+	}
+	if nodes[0].Job != deployment.JobMeta || nodes[0].Name != "m0" || nodes[0].Attrs[deployment.AttrStatus] != deployment.NodeStatusRunning {
+		t.Errorf("unexpected node %+v", nodes[0])
+	}
+	if nodes[1].Job != deployment.JobReplica || nodes[1].Name != "onebox-r1" || nodes[1].IPPort != "127.0.0.1:34801" {
+		t.Errorf("unexpected node %+v", nodes[1])
+	}
+}
+
+func TestPluginRetryableError(t *testing.T) {
+	d, err := newFakePlugin(t, "busy", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = d.StartNode(deployment.Node{Name: "r1", Job: deployment.JobReplica})
+	if err == nil {
+		t.Errorf("expect a retryable error: %v", err)
+	}
+}
+
+func TestPluginTimeout(t *testing.T) {
+	for _, mode := range []string{"hang", "hang-child"} {
+		t.Run(mode, func(t *testing.T) {
+			// the timeout doesn't apply to the capabilities, which may be slow under -race
+			d, err := newFakePlugin(t, mode, 500*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			err = d.StopNode(deployment.Node{Name: "r1", Job: deployment.JobReplica})
+			if err == nil || !strings.Contains(err.Error(), "timed out") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			// all processes of the plugin are killed, nothing holds the call
+			if elapsed := time.Since(start); elapsed > 30*time.Second {
+				t.Errorf("the call returns after %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestInvalidPlugin(t *testing.T) {
+	for mode, msg := range map[string]string{
+		"old-version": "protocol version 0",
+		"incapable":   "doesn't support the required operation \"start_node\"",
+		"crash":       "exit status 2",
+	} {
+		_, err := newFakePlugin(t, mode, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: unexpected error: %v", mode, err)
+		}
+	}
+	if _, err := New("onebox", "/not/exist/plugin", nil, time.Minute); err == nil {
+		t.Error("expect error for missing executable")
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plugin
+
+import (
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// ProtocolVersion is the version of the protocol that this client speaks. A plugin
+// must reply with the same version, the incompatible changes bump the version.
+const ProtocolVersion = 1
+
+// The operations of the protocol.
+const (
+	OpCapabilities  = "capabilities"
+	OpStartNode     = "start_node"
+	OpStopNode      = "stop_node"
+	OpRestartNode   = "restart_node"
+	OpRollingUpdate = "rolling_update"
+	OpListNodes     = "list_nodes"
+)
+
+// The operations that every plugin must support, except "capabilities" itself.
+var requiredOps = []string{OpStartNode, OpStopNode, OpRollingUpdate, OpListNodes}
+
+// Request is written as JSON to the stdin of the plugin, one request per process.
+type Request struct {
+	Version   int    `json:"version"`
+	Operation string `json:"operation"`
+	Cluster   string `json:"cluster"`
+
+	// Absent for "capabilities" and "list_nodes".
+	Node *Node `json:"node,omitempty"`
+}
+
+// Response is written as JSON to the stdout of the plugin. The plugin may log to stderr.
+type Response struct {
+	Version int `json:"version"`
+
+	// Whether the operation succeeds. If not, Error explains why, and Retryable tells
+	// whether the operation may succeed if issued again.
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	Retryable bool   `json:"retryable,omitempty"`
+
+	// For "capabilities": the name of the deployment system and the supported operations.
+	Name       string   `json:"name,omitempty"`
+	Operations []string `json:"operations,omitempty"`
+
+	// For "list_nodes".
+	Nodes []Node `json:"nodes,omitempty"`
+}
+
+// Node is deployment.Node on the wire, with the job in text.
+type Node struct {
+	// "meta", "replica" or "collector"
+	Job      string                 `json:"job"`
+	Name     string                 `json:"name"`
+	IPPort   string                 `json:"ip_port"`
+	Hostname string                 `json:"hostname,omitempty"`
+	Attrs    map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func toWireNode(n deployment.Node) *Node {
+	return &Node{
+		Job:      n.Job.String(),
+		Name:     n.Name,
+		IPPort:   n.IPPort,
+		Hostname: n.Hostname,
+		Attrs:    n.Attrs,
+	}
+}
@@ -0,0 +1,196 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The key of Node.Attrs that represents the running status of the node, and its values.
+const (
+	AttrStatus        = "Status"
+	NodeStatusRunning = "Running"
+	NodeStatusStopped = "Stopped"
+)
+
+// Retryable is implemented by the errors that know whether the failed call
+// may succeed if it's issued again, e.g. an HTTP 502 from the deployment service.
+type Retryable interface {
+	Retryable() bool
+}
+
+// RetryableError is a call rejected by the deployment system, which tells whether the
+// call may succeed if it's issued again.
+type RetryableError struct {
+	Message   string
+	Transient bool
+}
+
+func (e *RetryableError) Error() string {
+	return e.Message
+}
+
+func (e *RetryableError) Retryable() bool {
+	return e.Transient
+}
+
+// IsRetryableStatus returns whether a request that failed with the HTTP status code is transient.
+func IsRetryableStatus(code int) bool {
+	// the service is temporarily unavailable or overloaded
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// IsRetryable returns whether the error is transient. Network failures are always
+// considered transient, other errors are fatal unless they implement Retryable.
+func IsRetryable(err error) bool {
+	var r Retryable
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// RetryPolicy controls how the failed calls to a Deployment are retried.
+type RetryPolicy struct {
+	// The maximum number of calls, including the first one. 1 means no retry.
+	MaxAttempts int
+
+	// The backoff before the first retry, which grows by Multiplier after each retry.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+}
+
+// DefaultRetryPolicy retries for about 3 minutes at most.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: 5 * time.Second,
+	MaxBackoff:     time.Minute,
+	Multiplier:     2,
+}
+
+type retryDeployment struct {
+	Deployment
+
+	policy RetryPolicy
+}
+
+// WithRetry wraps the Deployment to retry the calls that failed with retryable errors.
+// Before StartNode/StopNode are issued again, the node status is checked if the
+// deployment reports it, in case the previous call has actually taken effect.
+// RestartNode and RollingUpdate are not retried, as the node status can't tell whether
+// they have taken effect, unless the restart falls back to StopNode and StartNode.
+func WithRetry(d Deployment, policy RetryPolicy) Deployment {
+	if policy.MaxAttempts <= 1 {
+		return d
+	}
+	return &retryDeployment{Deployment: d, policy: policy}
+}
+
+// retry calls `call` until it succeeds or fails with a fatal error. If `done` is not nil,
+// it's checked before each retry, and the retry is skipped if the call has taken effect.
+func (r *retryDeployment) retry(desc string, call func() error, done func() (bool, error)) error {
+	backoff := r.policy.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			return nil
+		}
+		if !IsRetryable(err) || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+		log.Warnf("%s failed (attempt %d/%d), retry after %s: %s", desc, attempt, r.policy.MaxAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = time.Duration(float64(backoff) * r.policy.Multiplier)
+		if r.policy.MaxBackoff > 0 && backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+
+		if done != nil {
+			ok, err := done()
+			if err != nil {
+				log.Warnf("unable to check whether %s has taken effect: %s", desc, err)
+			} else if ok {
+				log.Printf("%s has taken effect, skip retrying", desc)
+				return nil
+			}
+		}
+	}
+}
+
+func describeNodeOp(op string, node Node) string {
+	return fmt.Sprintf("%s on %s node %s", op, node.Job, node.Name)
+}
+
+// nodeInStatus returns whether the deployment reports the node in the given status.
+// It returns false if the deployment doesn't report node status.
+func (r *retryDeployment) nodeInStatus(node Node, status string) (bool, error) {
+	nodes, err := r.Deployment.ListAllNodes()
+	if err != nil {
+		return false, err
+	}
+	for _, n := range nodes {
+		if n.Job == node.Job && n.Name == node.Name {
+			return fmt.Sprint(n.Attrs[AttrStatus]) == status, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *retryDeployment) StartNode(node Node) error {
+	return r.retry(describeNodeOp("StartNode", node), func() error {
+		return r.Deployment.StartNode(node)
+	}, func() (bool, error) {
+		return r.nodeInStatus(node, NodeStatusRunning)
+	})
+}
+
+func (r *retryDeployment) StopNode(node Node) error {
+	return r.retry(describeNodeOp("StopNode", node), func() error {
+		return r.Deployment.StopNode(node)
+	}, func() (bool, error) {
+		return r.nodeInStatus(node, NodeStatusStopped)
+	})
+}
+
+func (r *retryDeployment) RestartNode(node Node) error {
+	if restarter, ok := r.Deployment.(Restarter); ok {
+		return restarter.RestartNode(node)
+	}
+	if err := r.StopNode(node); err != nil {
+		return err
+	}
+	return r.StartNode(node)
+}
+
+func (r *retryDeployment) ListAllNodes() ([]Node, error) {
+	var nodes []Node
+	err := r.retry("ListAllNodes", func() error {
+		var err error
+		nodes, err = r.Deployment.ListAllNodes()
+		return err
+	}, nil)
+	return nodes, err
+}
@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deployment
+
+import (
+	"errors"
+	"testing"
+)
+
+type testError struct {
+	retryable bool
+}
+
+func (e *testError) Error() string {
+	return "test error"
+}
+
+func (e *testError) Retryable() bool {
+	return e.retryable
+}
+
+var (
+	errTransient = &testError{retryable: true}
+	errFatal     = &testError{retryable: false}
+)
+
+// fakeDeployment fails the calls with the queued errors, and takes effect even if a call
+// fails when applyOnError is set.
+type fakeDeployment struct {
+	errs         []error
+	applyOnError bool
+	// the number of calls to each method
+	calls  map[string]int
+	status string
+}
+
+func newFakeDeployment(errs ...error) *fakeDeployment {
+	return &fakeDeployment{errs: errs, calls: map[string]int{}}
+}
+
+func (f *fakeDeployment) call(method string, status string) error {
+	f.calls[method]++
+	var err error
+	if len(f.errs) > 0 {
+		err, f.errs = f.errs[0], f.errs[1:]
+	}
+	if err == nil || f.applyOnError {
+		f.status = status
+	}
+	return err
+}
+
+func (f *fakeDeployment) StartNode(Node) error {
+	return f.call("StartNode", NodeStatusRunning)
+}
+
+func (f *fakeDeployment) StopNode(Node) error {
+	return f.call("StopNode", NodeStatusStopped)
+}
+
+func (f *fakeDeployment) RollingUpdate(Node) error {
+	return f.call("RollingUpdate", NodeStatusRunning)
+}
+
+func (f *fakeDeployment) ListAllNodes() ([]Node, error) {
+	f.calls["ListAllNodes"]++
+	n := Node{Job: JobReplica, Name: "1", Attrs: map[string]interface{}{}}
+	if f.status != "" {
+		n.Attrs[AttrStatus] = f.status
+	}
+	return []Node{n}, nil
+}
+
+func (f *fakeDeployment) Name() string {
+	return "fake"
+}
+
+func TestIsRetryable(t *testing.T) {
+	if !IsRetryable(errTransient) || IsRetryable(errFatal) || IsRetryable(errors.New("unknown")) {
+		t.Error("unexpected retryability")
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	for code, retryable := range map[int]bool{400: false, 404: false, 429: true, 500: true, 502: true} {
+		err := &RetryableError{Message: "failed", Transient: IsRetryableStatus(code)}
+		if IsRetryable(err) != retryable {
+			t.Errorf("HTTP %d: expect retryable %v", code, retryable)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	node := Node{Job: JobReplica, Name: "1"}
+	tests := []struct {
+		name         string
+		op           func(Deployment) error
+		method       string
+		errs         []error
+		applyOnError bool
+		// the expected number of calls to the method
+		calls int
+		err   error
+	}{
+		{name: "success", op: func(d Deployment) error { return d.StartNode(node) },
+			method: "StartNode", calls: 1},
+		{name: "retry transient", op: func(d Deployment) error { return d.StartNode(node) },
+			method: "StartNode", errs: []error{errTransient, errTransient}, calls: 3},
+		{name: "stop on fatal", op: func(d Deployment) error { return d.StopNode(node) },
+			method: "StopNode", errs: []error{errTransient, errFatal}, calls: 2, err: errFatal},
+		{name: "max attempts", op: func(d Deployment) error { return d.StartNode(node) },
+			method: "StartNode", errs: []error{errTransient, errTransient, errTransient, errTransient}, calls: 3, err: errTransient},
+		{name: "start has taken effect", op: func(d Deployment) error { return d.StartNode(node) },
+			method: "StartNode", errs: []error{errTransient}, applyOnError: true, calls: 1},
+		{name: "stop has taken effect", op: func(d Deployment) error { return d.StopNode(node) },
+			method: "StopNode", errs: []error{errTransient}, applyOnError: true, calls: 1},
+		// the node status can't tell whether a rolling update has taken effect
+		{name: "rolling update not retried", op: func(d Deployment) error { return d.RollingUpdate(node) },
+			method: "RollingUpdate", errs: []error{errTransient}, calls: 1, err: errTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeDeployment(tt.errs...)
+			f.applyOnError = tt.applyOnError
+			d := WithRetry(f, RetryPolicy{MaxAttempts: 3, Multiplier: 2})
+
+			if err := tt.op(d); err != tt.err {
+				t.Fatalf("expect error %v, got %v", tt.err, err)
+			}
+			if f.calls[tt.method] != tt.calls {
+				t.Fatalf("expect %d calls to %s, got %d", tt.calls, tt.method, f.calls[tt.method])
+			}
+		})
+	}
+}
+
+func TestWithRetryRestart(t *testing.T) {
+	f := newFakeDeployment(nil, errTransient)
+	d := WithRetry(f, RetryPolicy{MaxAttempts: 3})
+
+	// the deployment is not a Restarter, so only the failed start is issued again
+	if err := RestartNode(d, Node{Job: JobReplica, Name: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls["StopNode"] != 1 || f.calls["StartNode"] != 2 {
+		t.Fatalf("unexpected calls %v", f.calls)
+	}
+}
+
+type fakeRestarter struct {
+	*fakeDeployment
+}
+
+func (f *fakeRestarter) RestartNode(Node) error {
+	return f.call("RestartNode", NodeStatusRunning)
+}
+
+func TestWithRetryRestarter(t *testing.T) {
+	f := newFakeDeployment(errTransient)
+	d := WithRetry(&fakeRestarter{f}, RetryPolicy{MaxAttempts: 3})
+
+	// a restart can't be told from no restart by the node status
+	if err := RestartNode(d, Node{Job: JobReplica, Name: "1"}); err != errTransient {
+		t.Fatalf("expect the restart not retried, got %v", err)
+	}
+	if f.calls["RestartNode"] != 1 || f.calls["StopNode"] != 0 {
+		t.Fatalf("unexpected calls %v", f.calls)
+	}
+}
+
+func TestWithRetryDisabled(t *testing.T) {
+	f := newFakeDeployment()
+	if d := WithRetry(f, RetryPolicy{MaxAttempts: 1}); d != Deployment(f) {
+		t.Error("the deployment should not be wrapped without retry")
+	}
+}
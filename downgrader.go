@@ -20,7 +20,8 @@ type downgrader struct {
 	deploy deployment.Deployment
 }
 
-func newDowngrader(m metaApi.Meta, deploy deployment.Deployment) Downgrader {
+// newDowngrader is replaceable in tests.
+var newDowngrader = func(m metaApi.Meta, deploy deployment.Deployment) Downgrader {
 	return &downgrader{meta: m, deploy: deploy}
 }
 
@@ -0,0 +1,236 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// StateTable is the table in whose app envs the tool persists the cluster states that
+// MetaServer doesn't keep, so that they are shared among operators.
+var StateTable = "__stat"
+
+// The app env key under which the drained nodes are stored.
+const drainedEnvKey = "cluster_cli.drained_nodes"
+
+// drainedNode is a replica node in maintenance mode, which serves no replica.
+type drainedNode struct {
+	Name      string    `json:"name"`
+	IPPort    string    `json:"ip_port"`
+	DrainedAt time.Time `json:"drained_at"`
+}
+
+// drainedSet is persisted because the secondary blacklist in MetaServer is lost
+// after the primary meta changes. Every operation that rewrites the blacklist must
+// keep the drained nodes in it.
+type drainedSet struct {
+	Nodes []drainedNode `json:"nodes"`
+
+	// StateTable doesn't exist, so no node has been drained, and none can be
+	tableMissing bool
+}
+
+// loadDrainedSet returns the drained nodes, which is empty if StateTable doesn't exist.
+func loadDrainedSet(meta metaApi.Meta) (*drainedSet, error) {
+	s := &drainedSet{}
+	envs, err := meta.GetAppEnvs(StateTable)
+	if errors.Is(err, metaApi.ErrTableNotFound) {
+		log.Debugf("state table %s doesn't exist, no node is drained", StateTable)
+		s.tableMissing = true
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	val, ok := envs[drainedEnvKey]
+	if !ok || val == "" {
+		return s, nil
+	}
+	if err := json.Unmarshal([]byte(val), s); err != nil {
+		return nil, fmt.Errorf("corrupted drained nodes in app env \"%s\" of table %s: %s", drainedEnvKey, StateTable, err)
+	}
+	return s, nil
+}
+
+// checkWritable returns an error if the drained nodes can't be saved.
+func (s *drainedSet) checkWritable() error {
+	if s.tableMissing {
+		return fmt.Errorf("the drained nodes are stored in the app envs of table %s, which doesn't exist: "+
+			"create the table, or specify an existing one by --state-table", StateTable)
+	}
+	return nil
+}
+
+func (s *drainedSet) save(meta metaApi.Meta) error {
+	if s.tableMissing && len(s.Nodes) == 0 {
+		return nil
+	}
+	if err := s.checkWritable(); err != nil {
+		return err
+	}
+	if len(s.Nodes) == 0 {
+		return meta.DelAppEnvs(StateTable, []string{drainedEnvKey})
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return meta.UpdateAppEnvs(StateTable, map[string]string{drainedEnvKey: string(data)})
+}
+
+func (s *drainedSet) add(node *deployment.Node) {
+	for _, n := range s.Nodes {
+		if n.IPPort == node.IPPort {
+			return
+		}
+	}
+	s.Nodes = append(s.Nodes, drainedNode{Name: node.Name, IPPort: node.IPPort, DrainedAt: time.Now()})
+}
+
+// remove returns whether the node was drained.
+func (s *drainedSet) remove(node *deployment.Node) bool {
+	var rest []drainedNode
+	for _, n := range s.Nodes {
+		if n.IPPort != node.IPPort {
+			rest = append(rest, n)
+		}
+	}
+	removed := len(rest) != len(s.Nodes)
+	s.Nodes = rest
+	return removed
+}
+
+// blacklist returns the addresses of drained nodes along with the extra ones.
+func (s *drainedSet) blacklist(extra ...string) string {
+	addrSet := map[string]bool{}
+	for _, n := range s.Nodes {
+		addrSet[n.IPPort] = true
+	}
+	for _, addr := range extra {
+		addrSet[addr] = true
+	}
+	var addrs []string
+	for addr := range addrSet {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return strings.Join(addrs, ",")
+}
+
+// DrainNodes implements the drain-node command. It moves all replicas off the replica nodes
+// and keeps them from receiving new replicas, while the processes keep running.
+func DrainNodes(cluster string, deploy deployment.Deployment, nodeNames []string, limits WatchdogLimits) error {
+	meta, err := newMeta(cluster, deploy)
+	if err != nil {
+		return err
+	}
+	drained, err := loadDrainedSet(meta)
+	if err != nil {
+		return err
+	}
+	// fail before any node is drained
+	if err := drained.checkWritable(); err != nil {
+		return err
+	}
+	var nodes []*deployment.Node
+	for _, name := range nodeNames {
+		node, err := findReplicaNode(name)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, node)
+		drained.add(node)
+	}
+
+	if err := meta.SetMetaLevelSteady(); err != nil {
+		return err
+	}
+	if err := meta.AssignSecondaryBlackList(drained.blacklist()); err != nil {
+		return err
+	}
+	if err := drained.save(meta); err != nil {
+		return err
+	}
+	if err := meta.SetAssignDelayMs(10); err != nil {
+		return err
+	}
+
+	w, err := startWatchdog(meta, limits)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	down := newDowngrader(meta, deploy)
+	for _, nInfo := range nodes {
+		log.Printf("Draining replica node %s of %s ...", nInfo.Name, nInfo.IPPort)
+		node := util.NewNodeFromTCPAddr(nInfo.IPPort, session.NodeTypeReplica)
+		err := down.Downgrade(node)
+		if err == nil {
+			log.Print("Wait cluster to become healthy...")
+			err = waitClusterHealthy(meta, w)
+		}
+		if err != nil {
+			w.Stop()
+			revertCluster(meta)
+			return err
+		}
+		log.Printf("Node %s is drained", nInfo.IPPort)
+	}
+
+	return meta.ResetDefaultAssignDelayMs()
+}
+
+// UndrainNodes implements the undrain-node command. It lets the replica nodes serve again
+// and rebalances the cluster.
+func UndrainNodes(cluster string, deploy deployment.Deployment, nodeNames []string) error {
+	meta, err := newMeta(cluster, deploy)
+	if err != nil {
+		return err
+	}
+	drained, err := loadDrainedSet(meta)
+	if err != nil {
+		return err
+	}
+	for _, name := range nodeNames {
+		node, err := findReplicaNode(name)
+		if err != nil {
+			return err
+		}
+		drained.remove(node)
+	}
+
+	if err := meta.AssignSecondaryBlackList(drained.blacklist()); err != nil {
+		return err
+	}
+	if err := drained.save(meta); err != nil {
+		return err
+	}
+	return meta.Rebalance(false)
+}
@@ -0,0 +1,147 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDrainNodes(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802", "127.0.0.1:34803")
+	m.setDrained(replicaNode("3", "127.0.0.1:34803"))
+	useFakeMeta(t, m)
+	useFakeDowngrader(t, &fakeDowngrader{meta: m})
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"),
+		replicaNode("3", "127.0.0.1:34803"))
+
+	if err := DrainNodes("onebox", d, []string{"1"}, DefaultWatchdogLimits); err != nil {
+		t.Fatal(err)
+	}
+	drained, err := loadDrainedSet(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the nodes drained by others are kept
+	if drained.blacklist() != "127.0.0.1:34801,127.0.0.1:34803" {
+		t.Errorf("unexpected drained nodes %v", drained.Nodes)
+	}
+	if !m.called("AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803") || !m.called("Downgrade 127.0.0.1:34801") {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	if len(d.recorded()) != 0 {
+		t.Errorf("drained nodes should keep running: %v", d.recorded())
+	}
+
+	if err := UndrainNodes("onebox", d, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.called("AssignSecondaryBlackList 127.0.0.1:34803") || !m.called("Rebalance false") {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	if err := UndrainNodes("onebox", d, []string{"3"}); err != nil {
+		t.Fatal(err)
+	}
+	if !m.called("DelAppEnvs __stat " + drainedEnvKey) {
+		t.Errorf("the drained nodes should be cleared: %v", m.recorded())
+	}
+}
+
+func TestDrainNodesFailed(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	useFakeMeta(t, m)
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"))
+
+	// the node is not recorded drained if it can't be blacklisted
+	m.errs["AssignSecondaryBlackList"] = errors.New("timeout")
+	if err := DrainNodes("onebox", d, []string{"1"}, DefaultWatchdogLimits); err == nil {
+		t.Fatal("expect draining to fail")
+	}
+	if m.called("UpdateAppEnvs") {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+
+	m.envs[drainedEnvKey] = "{"
+	if err := DrainNodes("onebox", d, []string{"1"}, DefaultWatchdogLimits); err == nil {
+		t.Fatal("expect error on corrupted drained nodes")
+	}
+}
+
+// The operations that rewrite the secondary blacklist keep the drained nodes in it.
+func TestBlacklistKeepsDrainedNodes(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802", "127.0.0.1:34803")
+	m.setDrained(replicaNode("3", "127.0.0.1:34803"))
+	useFakeMeta(t, m)
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"),
+		replicaNode("3", "127.0.0.1:34803"))
+
+	if _, _, err := prepareRemoval("onebox", d, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	revertCluster(m)
+	expected := []string{
+		"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803",
+		"SetNodeLivePercentageZero",
+		"AssignSecondaryBlackList 127.0.0.1:34803",
+		"ResetDefaultAddSecondaryMaxCountForOneNode",
+		"ResetDefaultAssignDelayMs",
+		"SetMetaLevelLively",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+}
+
+// Without the state table, no node is drained, and only drain-node refuses to run.
+func TestMissingStateTable(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	m.noStateTable = true
+	useFakeMeta(t, m)
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"))
+
+	if _, _, err := prepareRemoval("onebox", d, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	revertCluster(m)
+	if err := UndrainNodes("onebox", d, []string{"1"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"AssignSecondaryBlackList 127.0.0.1:34801",
+		"SetNodeLivePercentageZero",
+		"AssignSecondaryBlackList",
+		"ResetDefaultAddSecondaryMaxCountForOneNode",
+		"ResetDefaultAssignDelayMs",
+		"SetMetaLevelLively",
+		"AssignSecondaryBlackList",
+		"Rebalance false",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+
+	calls := len(m.recorded())
+	err := DrainNodes("onebox", d, []string{"1"}, DefaultWatchdogLimits)
+	if err == nil || !strings.Contains(err.Error(), "--state-table") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.recorded()) != calls {
+		t.Errorf("nothing should be changed: %v", m.recorded()[calls:])
+	}
+}
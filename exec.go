@@ -0,0 +1,140 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/client"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// ExecOptions selects the nodes that a remote command is sent to. The conditions are
+// combined, and an empty condition matches every node.
+type ExecOptions struct {
+	// "meta", "replica" or "collector".
+	Job string
+
+	// The names of nodes.
+	Nodes []string
+
+	// The attributes that the nodes reported by the deployment must have.
+	Selector map[string]string
+
+	// The maximum number of nodes that the command is running on at the same time.
+	Concurrency int
+}
+
+// ExecResult is the outcome of a remote command on a node.
+type ExecResult struct {
+	Node   deployment.Node
+	Output string
+	Err    error
+}
+
+// Exec implements the exec command. It runs the remote command on the selected nodes,
+// and returns the results in the order of the nodes listed by the deployment.
+func Exec(deploy deployment.Deployment, command string, args []string, opts ExecOptions) ([]ExecResult, error) {
+	if opts.Concurrency <= 0 {
+		return nil, errors.New("concurrency must be positive")
+	}
+	allNodes, err := deploy.ListAllNodes()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := selectNodes(allNodes, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no node is matched in %d nodes", len(allNodes))
+	}
+
+	results := make([]ExecResult, len(nodes))
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, n deployment.Node) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			output, err := callCmd(n, command, args)
+			results[i] = ExecResult{Node: n, Output: output, Err: err}
+		}(i, n)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// callCmd sends the remote command to the node, replaceable in tests.
+var callCmd = func(n deployment.Node, command string, args []string) (string, error) {
+	nodeType := session.NodeTypeReplica
+	if n.Job == deployment.JobMeta {
+		nodeType = session.NodeTypeMeta
+	}
+	res := client.CallCmd(util.NewNodeFromTCPAddr(n.IPPort, nodeType), command, args)
+	return res.RespBody(), res.Error()
+}
+
+// selectNodes returns the nodes matching opts, it fails if any of opts.Nodes isn't deployed.
+func selectNodes(allNodes []deployment.Node, opts ExecOptions) ([]deployment.Node, error) {
+	names := map[string]bool{}
+	for _, name := range opts.Nodes {
+		names[name] = true
+	}
+	deployed := map[string]bool{}
+	for _, n := range allNodes {
+		deployed[n.Name] = true
+	}
+	var unknown []string
+	for _, name := range opts.Nodes {
+		if !deployed[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) != 0 {
+		return nil, fmt.Errorf("nodes %s were not found", strings.Join(unknown, ", "))
+	}
+	var nodes []deployment.Node
+	for _, n := range allNodes {
+		if opts.Job != "" && n.Job.String() != opts.Job {
+			continue
+		}
+		if len(names) != 0 && !names[n.Name] {
+			continue
+		}
+		matched := true
+		for k, v := range opts.Selector {
+			if attr, ok := n.Attrs[k]; !ok || fmt.Sprint(attr) != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes, nil
+}
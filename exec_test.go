@@ -0,0 +1,133 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+func newExecTest() *fakeDeployment {
+	running := map[string]interface{}{"Status": "Running"}
+	return newFakeDeployment(nil,
+		deployment.Node{Job: deployment.JobMeta, Name: "m0", IPPort: "127.0.0.1:34601", Attrs: running},
+		deployment.Node{Job: deployment.JobReplica, Name: "1", IPPort: "127.0.0.1:34801", Attrs: running},
+		deployment.Node{Job: deployment.JobReplica, Name: "2", IPPort: "127.0.0.1:34802",
+			Attrs: map[string]interface{}{"Status": "Stopped"}},
+		deployment.Node{Job: deployment.JobCollector, Name: "c0", IPPort: "127.0.0.1:34101"},
+	)
+}
+
+func TestSelectNodes(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ExecOptions
+		// the names of the selected nodes
+		nodes []string
+		// the expected error, empty means success
+		err string
+	}{
+		{name: "all", nodes: []string{"m0", "1", "2", "c0"}},
+		{name: "job", opts: ExecOptions{Job: "replica"}, nodes: []string{"1", "2"}},
+		{name: "names", opts: ExecOptions{Nodes: []string{"c0", "1"}}, nodes: []string{"1", "c0"}},
+		{name: "selector", opts: ExecOptions{Selector: map[string]string{"Status": "Running"}}, nodes: []string{"m0", "1"}},
+		{name: "combined", opts: ExecOptions{Job: "replica", Nodes: []string{"2", "m0"},
+			Selector: map[string]string{"Status": "Stopped"}}, nodes: []string{"2"}},
+		{name: "none matched", opts: ExecOptions{Job: "meta", Nodes: []string{"1"}}},
+		{name: "unknown names", opts: ExecOptions{Nodes: []string{"x", "1", "y"}}, err: "nodes x, y were not found"},
+	}
+	allNodes, _ := newExecTest().ListAllNodes()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := selectNodes(allNodes, tt.opts)
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var names []string
+			for _, n := range nodes {
+				names = append(names, n.Name)
+			}
+			if !equalCalls(names, tt.nodes) {
+				t.Errorf("unexpected nodes %v", names)
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	running, maxRunning := 0, 0
+	defer func(old func(deployment.Node, string, []string) (string, error)) { callCmd = old }(callCmd)
+	callCmd = func(n deployment.Node, command string, args []string) (string, error) {
+		mu.Lock()
+		calls = append(calls, n.IPPort+" "+command+" "+strings.Join(args, " "))
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+		if n.Name == "2" {
+			return "", errors.New("connection refused")
+		}
+		return "OK from " + n.Name, nil
+	}
+	d := newExecTest()
+
+	results, err := Exec(d, "flush-log", []string{"-v"}, ExecOptions{Job: "replica", Concurrency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in the order of the deployment, the failure on a node doesn't fail the others
+	if len(results) != 2 || results[0].Node.Name != "1" || results[0].Output != "OK from 1" || results[0].Err != nil ||
+		results[1].Node.Name != "2" || results[1].Err == nil {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if maxRunning != 1 {
+		t.Errorf("%d nodes were running the command at the same time", maxRunning)
+	}
+	if len(calls) != 2 || !strings.HasSuffix(calls[0], " flush-log -v") {
+		t.Errorf("unexpected calls %v", calls)
+	}
+
+	calls = nil
+	for _, opts := range []ExecOptions{
+		{Concurrency: 0},
+		{Job: "collector", Nodes: []string{"1"}, Concurrency: 1},
+		{Nodes: []string{"3"}, Concurrency: 1},
+	} {
+		if _, err := Exec(d, "server-info", nil, opts); err == nil {
+			t.Errorf("expect error with %+v", opts)
+		}
+	}
+	if len(calls) != 0 {
+		t.Errorf("no command should be sent: %v", calls)
+	}
+}
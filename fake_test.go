@@ -0,0 +1,494 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/pegasus-kv/admin-cli/client"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// fakeMeta is an in-memory MetaServer. The methods that are not implemented panic
+// through the embedded nil interface, which fails the test on unexpected calls.
+type fakeMeta struct {
+	metaApi.Meta
+
+	mu sync.Mutex
+	// the mutating calls in order, like "SetAssignDelayMs 10"
+	calls []string
+	// the errors returned by the methods, keyed by method name
+	errs map[string]error
+
+	cluster     string
+	primaryMeta string
+	// the replica nodes by address, true if alive
+	nodes map[string]bool
+	// the number of unhealthy partitions reported by successive queries, the last one is kept
+	unhealthy []int32
+	// the number of partitions with a single live replica
+	singleReplica int32
+	partitions    []*replication.PartitionConfiguration
+	tables        []*admin.AppInfo
+	backups       *admin.QueryBackupPolicyResponse
+	// the partition counts of the tables created by CreateTable, which are always healthy
+	createdTables map[string]int
+	// the app envs of StateTable
+	envs map[string]string
+	// StateTable doesn't exist
+	noStateTable bool
+}
+
+func newFakeMeta(replicaAddrs ...string) *fakeMeta {
+	m := &fakeMeta{
+		errs:        map[string]error{},
+		cluster:     "onebox",
+		primaryMeta: "127.0.0.1:34601",
+		nodes:       map[string]bool{},
+		envs:        map[string]string{},
+
+		createdTables: map[string]int{},
+	}
+	for _, addr := range replicaAddrs {
+		m.nodes[addr] = true
+	}
+	return m
+}
+
+func (m *fakeMeta) record(method string, args ...interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	call := strings.TrimSpace(method + " " + fmt.Sprintln(args...))
+	m.calls = append(m.calls, call)
+	return m.errs[method]
+}
+
+func (m *fakeMeta) recorded() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.calls...)
+}
+
+// called returns whether the call (or any call of the method if no args given) is recorded.
+func (m *fakeMeta) called(call string) bool {
+	for _, c := range m.recorded() {
+		if c == call || strings.HasPrefix(c, call+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *fakeMeta) setAlive(addr string, alive bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[addr] = alive
+}
+
+func (m *fakeMeta) queryErr(method string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errs[method]
+}
+
+func toRPCAddress(addr string) *base.RPCAddress {
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(portStr)
+	return base.NewRPCAddress(net.ParseIP(host), port)
+}
+
+func nodeStatus(alive bool) admin.NodeStatus {
+	if alive {
+		return admin.NodeStatus_NS_ALIVE
+	}
+	return admin.NodeStatus_NS_UNALIVE
+}
+
+func (m *fakeMeta) GetClusterInfo() (*metaApi.ClusterInfo, error) {
+	if err := m.queryErr("GetClusterInfo"); err != nil {
+		return nil, err
+	}
+	return &metaApi.ClusterInfo{Cluster: m.cluster, PrimaryMeta: m.primaryMeta}, nil
+}
+
+func (m *fakeMeta) ListNodes() ([]*admin.NodeInfo, error) {
+	if err := m.queryErr("ListNodes"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var infos []*admin.NodeInfo
+	for addr, alive := range m.nodes {
+		infos = append(infos, &admin.NodeInfo{Status: nodeStatus(alive), Address: toRPCAddress(addr)})
+	}
+	return infos, nil
+}
+
+func (m *fakeMeta) GetClusterReplicaInfo() (*client.ClusterReplicaInfo, error) {
+	if err := m.queryErr("GetClusterReplicaInfo"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var unhealthy int32
+	if len(m.unhealthy) > 0 {
+		unhealthy = m.unhealthy[0]
+		if len(m.unhealthy) > 1 {
+			m.unhealthy = m.unhealthy[1:]
+		}
+	}
+	info := &client.ClusterReplicaInfo{
+		Tables: []*client.TableHealthInfo{{PartitionCount: 8, Unhealthy: unhealthy, WriteUnhealthy: m.singleReplica}},
+	}
+	for addr, alive := range m.nodes {
+		info.Nodes = append(info.Nodes, &client.NodeState{IPPort: addr, Status: nodeStatus(alive)})
+	}
+	return info, nil
+}
+
+func (m *fakeMeta) SetMetaLevelSteady() error {
+	return m.record("SetMetaLevelSteady")
+}
+
+func (m *fakeMeta) SetMetaLevelLively() error {
+	return m.record("SetMetaLevelLively")
+}
+
+func (m *fakeMeta) SetMetaLevelBlind() error {
+	return m.record("SetMetaLevelBlind")
+}
+
+func (m *fakeMeta) ResetDefaultAddSecondaryMaxCountForOneNode() error {
+	return m.record("ResetDefaultAddSecondaryMaxCountForOneNode")
+}
+
+func (m *fakeMeta) SetAddSecondaryMaxCountForOneNode(num int) error {
+	return m.record("SetAddSecondaryMaxCountForOneNode", num)
+}
+
+func (m *fakeMeta) GetAddSecondaryMaxCountForOneNode() (int, error) {
+	if err := m.queryErr("GetAddSecondaryMaxCountForOneNode"); err != nil {
+		return 0, err
+	}
+	return 10, nil
+}
+
+func (m *fakeMeta) SetAddSecondaryEnableFlowControl(enable bool) error {
+	return m.record("SetAddSecondaryEnableFlowControl", enable)
+}
+
+func (m *fakeMeta) GetAddSecondaryEnableFlowControl() (bool, error) {
+	if err := m.queryErr("GetAddSecondaryEnableFlowControl"); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+func (m *fakeMeta) SetReplicaCopyRate(megabytes int) error {
+	return m.record("SetReplicaCopyRate", megabytes)
+}
+
+func (m *fakeMeta) GetReplicaCopyRate() (int, error) {
+	if err := m.queryErr("GetReplicaCopyRate"); err != nil {
+		return 0, err
+	}
+	return 500, nil
+}
+
+func (m *fakeMeta) ListPartitions() ([]*replication.PartitionConfiguration, error) {
+	if err := m.queryErr("ListPartitions"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*replication.PartitionConfiguration(nil), m.partitions...), nil
+}
+
+// MovePrimary switches the roles of the primary and the secondary at once.
+func (m *fakeMeta) MovePrimary(gpid *base.Gpid, from *util.PegasusNode, to *util.PegasusNode) error {
+	if err := m.record("MovePrimary", fmt.Sprintf("%d.%d", gpid.Appid, gpid.PartitionIndex), from.TCPAddr(), to.TCPAddr()); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, part := range m.partitions {
+		if *part.Pid != *gpid {
+			continue
+		}
+		moved := *part
+		moved.Primary = to.RPCAddress()
+		moved.Secondaries = nil
+		for _, sec := range part.Secondaries {
+			if sec.GetAddress() == to.TCPAddr() {
+				sec = from.RPCAddress()
+			}
+			moved.Secondaries = append(moved.Secondaries, sec)
+		}
+		m.partitions[i] = &moved
+	}
+	return nil
+}
+
+func (m *fakeMeta) ListTables() ([]*admin.AppInfo, error) {
+	if err := m.queryErr("ListTables"); err != nil {
+		return nil, err
+	}
+	return m.tables, nil
+}
+
+func (m *fakeMeta) QueryBackupPolicies() (*admin.QueryBackupPolicyResponse, error) {
+	if err := m.queryErr("QueryBackupPolicies"); err != nil {
+		return nil, err
+	}
+	if m.backups == nil {
+		return &admin.QueryBackupPolicyResponse{}, nil
+	}
+	return m.backups, nil
+}
+
+func (m *fakeMeta) GetAppEnvs(tableName string) (map[string]string, error) {
+	if err := m.queryErr("GetAppEnvs"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTable(tableName); err != nil {
+		return nil, err
+	}
+	envs := map[string]string{}
+	for k, v := range m.envs {
+		envs[k] = v
+	}
+	return envs, nil
+}
+
+// checkTable returns an error if the table doesn't exist, the caller must hold m.mu.
+func (m *fakeMeta) checkTable(tableName string) error {
+	if tableName != StateTable || m.noStateTable {
+		return fmt.Errorf("%w: \"%s\"", metaApi.ErrTableNotFound, tableName)
+	}
+	return nil
+}
+
+func (m *fakeMeta) UpdateAppEnvs(tableName string, envs map[string]string) error {
+	var args []string
+	for k, v := range envs {
+		args = append(args, k+"="+v)
+	}
+	if err := m.record("UpdateAppEnvs", tableName, strings.Join(args, " ")); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTable(tableName); err != nil {
+		return err
+	}
+	for k, v := range envs {
+		m.envs[k] = v
+	}
+	return nil
+}
+
+func (m *fakeMeta) DelAppEnvs(tableName string, keys []string) error {
+	if err := m.record("DelAppEnvs", tableName, strings.Join(keys, " ")); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.checkTable(tableName); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		delete(m.envs, k)
+	}
+	return nil
+}
+
+// setDrained stores the drained nodes in the app envs, as if drain-node has run.
+func (m *fakeMeta) setDrained(nodes ...deployment.Node) {
+	s := &drainedSet{}
+	for _, n := range nodes {
+		s.add(&n)
+	}
+	data, _ := json.Marshal(s)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.envs[drainedEnvKey] = string(data)
+}
+
+func (m *fakeMeta) SetNodeLivePercentageZero() error {
+	return m.record("SetNodeLivePercentageZero")
+}
+
+func (m *fakeMeta) AssignSecondaryBlackList(blacklist string) error {
+	return m.record("AssignSecondaryBlackList", blacklist)
+}
+
+func (m *fakeMeta) SetAssignDelayMs(delayMs int) error {
+	return m.record("SetAssignDelayMs", delayMs)
+}
+
+func (m *fakeMeta) ResetDefaultAssignDelayMs() error {
+	return m.record("ResetDefaultAssignDelayMs")
+}
+
+func (m *fakeMeta) Rebalance(primaryOnly bool) error {
+	return m.record("Rebalance", primaryOnly)
+}
+
+func (m *fakeMeta) CreateTable(tableName string, partitionCount int) error {
+	if err := m.record("CreateTable", tableName, partitionCount); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.createdTables[tableName] = partitionCount
+	return nil
+}
+
+func (m *fakeMeta) GetTableHealthInfo(tableName string) (*client.TableHealthInfo, error) {
+	if err := m.queryErr("GetTableHealthInfo"); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count, ok := m.createdTables[tableName]
+	if !ok {
+		return nil, fmt.Errorf("table %s is not found", tableName)
+	}
+	return &client.TableHealthInfo{PartitionCount: int32(count), FullHealthy: int32(count)}, nil
+}
+
+// fakeDeployment operates the nodes of fakeMeta: the replica nodes become alive once
+// started, and dead once stopped.
+type fakeDeployment struct {
+	meta  *fakeMeta
+	nodes []deployment.Node
+
+	mu sync.Mutex
+	// the calls in order, like "StopNode replica 1"
+	calls []string
+	// the errors returned by the operations, keyed by "StopNode" or "StopNode replica 1"
+	errs map[string]error
+}
+
+func newFakeDeployment(meta *fakeMeta, nodes ...deployment.Node) *fakeDeployment {
+	return &fakeDeployment{meta: meta, nodes: nodes, errs: map[string]error{}}
+}
+
+func (d *fakeDeployment) operate(op string, node deployment.Node, alive bool) error {
+	d.mu.Lock()
+	call := fmt.Sprintf("%s %s %s", op, node.Job, node.Name)
+	d.calls = append(d.calls, call)
+	err, ok := d.errs[call]
+	if !ok {
+		err = d.errs[op]
+	}
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if node.Job == deployment.JobReplica && d.meta != nil {
+		d.meta.setAlive(node.IPPort, alive)
+	}
+	return nil
+}
+
+func (d *fakeDeployment) recorded() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.calls...)
+}
+
+func (d *fakeDeployment) StartNode(node deployment.Node) error {
+	return d.operate("StartNode", node, true)
+}
+
+func (d *fakeDeployment) StopNode(node deployment.Node) error {
+	return d.operate("StopNode", node, false)
+}
+
+func (d *fakeDeployment) RollingUpdate(node deployment.Node) error {
+	return d.operate("RollingUpdate", node, true)
+}
+
+func (d *fakeDeployment) ListAllNodes() ([]deployment.Node, error) {
+	return d.nodes, nil
+}
+
+func (d *fakeDeployment) Name() string {
+	return "fake"
+}
+
+// useFakeMeta makes the operations connect to m during the test.
+func useFakeMeta(t *testing.T, m *fakeMeta) {
+	old := newMetaClient
+	newMetaClient = func(cluster string, metaList []string) (metaApi.Meta, error) {
+		if cluster != m.cluster {
+			return nil, fmt.Errorf("%w, got '%s'", metaApi.ErrClusterNotMatched, m.cluster)
+		}
+		return m, nil
+	}
+	t.Cleanup(func() { newMetaClient = old })
+}
+
+// useFakeDowngrader makes the operations downgrade nodes by down during the test.
+func useFakeDowngrader(t *testing.T, down *fakeDowngrader) {
+	old := newDowngrader
+	newDowngrader = func(m metaApi.Meta, deploy deployment.Deployment) Downgrader {
+		return down
+	}
+	t.Cleanup(func() { newDowngrader = old })
+}
+
+func replicaNode(name string, addr string) deployment.Node {
+	return deployment.Node{Job: deployment.JobReplica, Name: name, IPPort: addr}
+}
+
+func equalCalls(actual []string, expected []string) bool {
+	return strings.Join(actual, "\n") == strings.Join(expected, "\n")
+}
+
+// fakeDowngrader records the downgrade in fakeMeta without changing the node state, so the
+// cluster is always healthy right after.
+type fakeDowngrader struct {
+	meta *fakeMeta
+	err  error
+
+	downgraded []string
+}
+
+func (d *fakeDowngrader) Downgrade(node *util.PegasusNode) error {
+	d.meta.record("Downgrade", node.TCPAddr())
+	if d.err != nil {
+		return d.err
+	}
+	d.downgraded = append(d.downgraded, node.TCPAddr())
+	return nil
+}
@@ -10,6 +10,7 @@ require (
 	github.com/pegasus-kv/collector v0.0.0-20201231071707-f7bf1d568242
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.1.3
+	github.com/spf13/pflag v1.0.5
 	github.com/stretchr/testify v1.7.0 // indirect
-	github.com/tidwall/gjson v1.7.5 // indirect
+	github.com/tidwall/gjson v1.7.5
 )
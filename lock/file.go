@@ -0,0 +1,138 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// fileLock stores the lock as a file. To lock across machines, the directory
+// should be on a shared file system that supports flock, like NFS.
+type fileLock struct {
+	path string
+}
+
+// NewFileLock returns a Lock of the cluster that is stored under dir.
+func NewFileLock(dir string, cluster string) Lock {
+	return &fileLock{path: filepath.Join(dir, cluster+".lock")}
+}
+
+func (l *fileLock) dir() string {
+	return filepath.Dir(l.path)
+}
+
+func (l *fileLock) Acquire(info Info) error {
+	return l.guarded(func() error {
+		holder, err := l.Status()
+		if err != nil {
+			return err
+		}
+		if holder != nil && !holder.Expired() {
+			return fmt.Errorf("cluster %s is locked: %s", info.Cluster, holder)
+		}
+		return l.write(info)
+	})
+}
+
+func (l *fileLock) Refresh(info Info) error {
+	return l.guarded(func() error {
+		holder, err := l.Status()
+		if err != nil {
+			return err
+		}
+		if holder == nil || holder.ID != info.ID {
+			return ErrTakenOver
+		}
+		return l.write(info)
+	})
+}
+
+func (l *fileLock) Release(info Info) error {
+	return l.guarded(func() error {
+		holder, err := l.Status()
+		if err != nil {
+			return err
+		}
+		if holder == nil || holder.ID != info.ID {
+			return nil
+		}
+		return l.remove()
+	})
+}
+
+func (l *fileLock) Status() (*Info, error) {
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("corrupted lock file %s: %s", l.path, err)
+	}
+	return &info, nil
+}
+
+func (l *fileLock) Break() error {
+	return l.guarded(l.remove)
+}
+
+// guarded runs fn while holding an exclusive flock on the guard file beside the lock file,
+// so that the check-then-write sequences of concurrent operators never interleave.
+func (l *fileLock) guarded(fn func() error) error {
+	if err := os.MkdirAll(l.dir(), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path+".guard", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("unable to flock %s: %s", f.Name(), err)
+	}
+	defer func() { _ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }()
+	return fn()
+}
+
+// write replaces the lock file atomically, so that the readers never see a partial file.
+func (l *fileLock) write(info Info) error {
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *fileLock) remove() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestFileLock(t *testing.T) Lock {
+	dir, err := ioutil.TempDir("", "cluster-cli-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return NewFileLock(dir, "onebox")
+}
+
+// testLockSemantics checks the behaviors that every Lock implementation shares.
+func testLockSemantics(t *testing.T, l Lock) {
+	alice := NewInfo("onebox", "alice", "rolling-update", time.Hour)
+	bob := NewInfo("onebox", "bob", "remove-node", time.Hour)
+
+	if holder, err := l.Status(); err != nil || holder != nil {
+		t.Fatalf("expect a free lock, got %v %v", holder, err)
+	}
+	if err := l.Acquire(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(bob); err == nil {
+		t.Fatal("the lock should be held by alice")
+	}
+	if holder, err := l.Status(); err != nil || holder.ID != alice.ID {
+		t.Fatalf("expect the lock held by alice, got %v %v", holder, err)
+	}
+	if err := l.Refresh(bob); !errors.Is(err, ErrTakenOver) {
+		t.Errorf("bob can't refresh the lock of alice, got %v", err)
+	}
+	// bob can't release the lock of alice
+	if err := l.Release(bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Refresh(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Release(alice); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := l.Status(); err != nil || holder != nil {
+		t.Fatalf("expect a free lock, got %v %v", holder, err)
+	}
+
+	// the expired lock is taken over
+	expired := NewInfo("onebox", "alice", "rolling-update", time.Hour)
+	expired.ExpireAt = time.Now().Add(-time.Second)
+	if err := l.Acquire(expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Acquire(bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Refresh(expired); !errors.Is(err, ErrTakenOver) {
+		t.Errorf("the lock should be taken over, got %v", err)
+	}
+
+	if err := l.Break(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := l.Status(); err != nil || holder != nil {
+		t.Fatalf("expect a free lock after break, got %v %v", holder, err)
+	}
+}
+
+func TestFileLock(t *testing.T) {
+	testLockSemantics(t, newTestFileLock(t))
+}
+
+func TestFileLockConcurrentTakeover(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		l := newTestFileLock(t)
+		expired := NewInfo("onebox", "alice", "rolling-update", time.Hour)
+		expired.ExpireAt = time.Now().Add(-time.Second)
+		if err := l.Acquire(expired); err != nil {
+			t.Fatal(err)
+		}
+
+		// every operator sees the expired lock, only one of them takes it over
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var winners []Info
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				info := NewInfo("onebox", "bob", "remove-node", time.Hour)
+				if err := NewFileLock(l.(*fileLock).dir(), "onebox").Acquire(info); err == nil {
+					mu.Lock()
+					winners = append(winners, info)
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if len(winners) != 1 {
+			t.Fatalf("expect exactly one operator to hold the lock, got %d", len(winners))
+		}
+		if holder, err := l.Status(); err != nil || holder.ID != winners[0].ID {
+			t.Fatalf("the lock is not held by the winner: %v %v", holder, err)
+		}
+	}
+}
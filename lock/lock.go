@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package lock prevents multiple operators from operating the same cluster simultaneously.
+package lock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Info describes the holder of a cluster lock.
+type Info struct {
+	// ID is a random token that uniquely identifies the holder, so that one never
+	// releases a lock held by others.
+	ID string `json:"id"`
+
+	Owner      string    `json:"owner"`
+	Command    string    `json:"command"`
+	Cluster    string    `json:"cluster"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpireAt   time.Time `json:"expire_at"`
+}
+
+// NewInfo returns the lock Info of the current process.
+func NewInfo(cluster string, owner string, command string, ttl time.Duration) Info {
+	hostname, _ := os.Hostname()
+	token := make([]byte, 8)
+	_, _ = rand.Read(token)
+	now := time.Now()
+	return Info{
+		ID:         fmt.Sprintf("%s@%s-%d-%s", owner, hostname, os.Getpid(), hex.EncodeToString(token)),
+		Owner:      owner,
+		Command:    command,
+		Cluster:    cluster,
+		AcquiredAt: now,
+		ExpireAt:   now.Add(ttl),
+	}
+}
+
+// Expired returns whether the lock can be taken over by others.
+func (i *Info) Expired() bool {
+	return time.Now().After(i.ExpireAt)
+}
+
+func (i *Info) String() string {
+	return fmt.Sprintf("held by %s for \"%s\" since %s, expires at %s", i.Owner, i.Command,
+		i.AcquiredAt.Format(time.RFC3339), i.ExpireAt.Format(time.RFC3339))
+}
+
+// ErrTakenOver is returned by Lock.Refresh if the lock is held by others.
+var ErrTakenOver = errors.New("the lock was taken over by others")
+
+// Lock is a cluster-wide mutual exclusion of operations.
+type Lock interface {
+	// Acquire takes the lock, fails if the lock is held by others and not expired.
+	Acquire(info Info) error
+
+	// Refresh extends the expiry of the lock held by info.
+	Refresh(info Info) error
+
+	// Release gives up the lock if it's still held by info.
+	Release(info Info) error
+
+	// Status returns the current holder of the lock, or nil if it's free.
+	Status() (*Info, error)
+
+	// Break forcibly releases the lock no matter who holds it.
+	Break() error
+}
+
+// Holder holds a lock and keeps refreshing it until released. If the lock is lost,
+// e.g. broken and then taken over by others, Lost is closed and the operation is
+// expected to abort.
+type Holder struct {
+	lock Lock
+	info Info
+	ttl  time.Duration
+
+	mu  sync.Mutex
+	err error
+
+	lostCh chan struct{}
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// Hold acquires the lock for info, and refreshes its expiry periodically in background.
+func Hold(l Lock, info Info) (*Holder, error) {
+	if !info.ExpireAt.After(info.AcquiredAt) {
+		return nil, fmt.Errorf("invalid lock expiry %s", info.ExpireAt.Format(time.RFC3339))
+	}
+	if err := l.Acquire(info); err != nil {
+		return nil, err
+	}
+	h := &Holder{
+		lock:   l,
+		info:   info,
+		ttl:    info.ExpireAt.Sub(info.AcquiredAt),
+		lostCh: make(chan struct{}),
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+	go h.loop()
+	return h, nil
+}
+
+func (h *Holder) loop() {
+	defer close(h.doneCh)
+	ticker := time.NewTicker(h.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case <-ticker.C:
+		}
+		info := h.info
+		info.ExpireAt = time.Now().Add(h.ttl)
+		err := h.lock.Refresh(info)
+		if err == nil {
+			h.info = info
+			continue
+		}
+		if !errors.Is(err, ErrTakenOver) && !h.info.Expired() {
+			// the lock is still valid, try again later
+			log.Errorf("failed to refresh the cluster lock: %s", err)
+			continue
+		}
+		if !errors.Is(err, ErrTakenOver) {
+			err = fmt.Errorf("the lock expired since it can't be refreshed: %s", err)
+		}
+		log.Errorf("lost the cluster lock: %s", err)
+		h.mu.Lock()
+		h.err = err
+		h.mu.Unlock()
+		close(h.lostCh)
+		return
+	}
+}
+
+// Lost is closed once the lock is lost.
+func (h *Holder) Lost() <-chan struct{} {
+	return h.lostCh
+}
+
+// Err returns why the lock is lost, or nil if it's still held.
+func (h *Holder) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.err
+}
+
+// Release stops refreshing and releases the lock.
+func (h *Holder) Release() error {
+	close(h.stopCh)
+	<-h.doneCh
+	return h.lock.Release(h.info)
+}
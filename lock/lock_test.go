@@ -0,0 +1,136 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyLock fails the refreshes as told.
+type flakyLock struct {
+	mu         sync.Mutex
+	refreshErr error
+	refreshes  int
+	released   bool
+}
+
+func (l *flakyLock) Acquire(info Info) error {
+	return nil
+}
+
+func (l *flakyLock) Refresh(info Info) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refreshes++
+	return l.refreshErr
+}
+
+func (l *flakyLock) Release(info Info) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = true
+	return nil
+}
+
+func (l *flakyLock) Status() (*Info, error) {
+	return nil, nil
+}
+
+func (l *flakyLock) Break() error {
+	return nil
+}
+
+func (l *flakyLock) setRefreshErr(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refreshErr = err
+}
+
+func (l *flakyLock) refreshCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.refreshes
+}
+
+func waitLost(h *Holder, timeout time.Duration) bool {
+	select {
+	case <-h.Lost():
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestHolderRefreshes(t *testing.T) {
+	l := &flakyLock{}
+	h, err := Hold(l, NewInfo("onebox", "alice", "rolling-update", 300*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the transient failures are tolerated before the lock expires
+	l.setRefreshErr(errors.New("timeout"))
+	time.Sleep(150 * time.Millisecond)
+	l.setRefreshErr(nil)
+	if waitLost(h, 500*time.Millisecond) {
+		t.Fatalf("the lock should be held, got %v", h.Err())
+	}
+	if l.refreshCount() < 2 {
+		t.Errorf("expect the lock to be refreshed periodically, got %d refreshes", l.refreshCount())
+	}
+	if err := h.Release(); err != nil || !l.released {
+		t.Errorf("the lock is not released: %v", err)
+	}
+}
+
+func TestHolderLost(t *testing.T) {
+	tests := []struct {
+		name       string
+		refreshErr error
+	}{
+		{name: "taken over", refreshErr: ErrTakenOver},
+		{name: "expired", refreshErr: errors.New("timeout")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &flakyLock{refreshErr: tt.refreshErr}
+			h, err := Hold(l, NewInfo("onebox", "alice", "rolling-update", 30*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !waitLost(h, time.Second) {
+				t.Fatal("the lock should be lost")
+			}
+			if h.Err() == nil {
+				t.Error("expect the reason of losing the lock")
+			}
+			if err := h.Release(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHoldInvalidExpiry(t *testing.T) {
+	info := NewInfo("onebox", "alice", "rolling-update", 0)
+	if _, err := Hold(&flakyLock{}, info); err == nil {
+		t.Error("expect error on invalid expiry")
+	}
+}
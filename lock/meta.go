@@ -0,0 +1,123 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// The app env key under which the lock is stored.
+const metaLockEnvKey = "cluster_cli.lock"
+
+// how long to wait before confirming the lock is acquired, changeable in tests
+var metaLockConfirmDelay = time.Second
+
+// metaLock stores the lock in the app envs of a reserved table, so that it's visible
+// to every operator that can access the cluster.
+//
+// The lock is advisory. App envs have no compare-and-swap, so Acquire writes the random ID
+// of the holder and confirms it's not overwritten after metaLockConfirmDelay. An acquirer
+// whose write is delayed beyond that still overwrites the confirmed holder, who then
+// finds the lock taken over on the next refresh and aborts.
+type metaLock struct {
+	meta  meta.Meta
+	table string
+}
+
+// NewMetaLock returns a Lock that is stored in the app envs of the table.
+func NewMetaLock(m meta.Meta, table string) Lock {
+	return &metaLock{meta: m, table: table}
+}
+
+func (l *metaLock) Acquire(info Info) error {
+	holder, err := l.Status()
+	if err != nil {
+		return err
+	}
+	if holder != nil && !holder.Expired() {
+		return fmt.Errorf("cluster %s is locked: %s", info.Cluster, holder)
+	}
+	if err := l.put(info); err != nil {
+		return err
+	}
+
+	// confirm that we are not overwritten by others who acquire simultaneously
+	time.Sleep(metaLockConfirmDelay)
+	holder, err = l.Status()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != info.ID {
+		return fmt.Errorf("cluster %s is locked by others simultaneously", info.Cluster)
+	}
+	return nil
+}
+
+func (l *metaLock) Refresh(info Info) error {
+	holder, err := l.Status()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != info.ID {
+		return ErrTakenOver
+	}
+	return l.put(info)
+}
+
+func (l *metaLock) Release(info Info) error {
+	holder, err := l.Status()
+	if err != nil {
+		return err
+	}
+	if holder == nil || holder.ID != info.ID {
+		return nil
+	}
+	return l.Break()
+}
+
+func (l *metaLock) Status() (*Info, error) {
+	envs, err := l.meta.GetAppEnvs(l.table)
+	if err != nil {
+		return nil, err
+	}
+	val, ok := envs[metaLockEnvKey]
+	if !ok || val == "" {
+		return nil, nil
+	}
+	var info Info
+	if err := json.Unmarshal([]byte(val), &info); err != nil {
+		return nil, fmt.Errorf("corrupted lock in app env \"%s\" of table %s: %s", metaLockEnvKey, l.table, err)
+	}
+	return &info, nil
+}
+
+func (l *metaLock) Break() error {
+	return l.meta.DelAppEnvs(l.table, []string{metaLockEnvKey})
+}
+
+func (l *metaLock) put(info Info) error {
+	data, err := json.Marshal(&info)
+	if err != nil {
+		return err
+	}
+	return l.meta.UpdateAppEnvs(l.table, map[string]string{metaLockEnvKey: string(data)})
+}
@@ -0,0 +1,105 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pegasus-kv/cluster-cli/meta"
+)
+
+// fakeMeta keeps the app envs in memory. The other methods panic through the embedded
+// nil interface.
+type fakeMeta struct {
+	meta.Meta
+
+	mu   sync.Mutex
+	envs map[string]string
+}
+
+func (m *fakeMeta) GetAppEnvs(tableName string) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	envs := map[string]string{}
+	for k, v := range m.envs {
+		envs[k] = v
+	}
+	return envs, nil
+}
+
+func (m *fakeMeta) UpdateAppEnvs(tableName string, envs map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, v := range envs {
+		m.envs[k] = v
+	}
+	return nil
+}
+
+func (m *fakeMeta) DelAppEnvs(tableName string, keys []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range keys {
+		delete(m.envs, k)
+	}
+	return nil
+}
+
+func init() {
+	metaLockConfirmDelay = time.Millisecond
+}
+
+func TestMetaLock(t *testing.T) {
+	testLockSemantics(t, NewMetaLock(&fakeMeta{envs: map[string]string{}}, "__stat"))
+}
+
+func TestMetaLockCorrupted(t *testing.T) {
+	l := NewMetaLock(&fakeMeta{envs: map[string]string{metaLockEnvKey: "{"}}, "__stat")
+	if _, err := l.Status(); err == nil {
+		t.Error("expect error on corrupted lock")
+	}
+}
+
+func TestMetaLockOverwrittenAfterConfirmed(t *testing.T) {
+	l := NewMetaLock(&fakeMeta{envs: map[string]string{}}, "__stat")
+	alice := NewInfo("onebox", "alice", "rolling-update", time.Hour)
+	bob := NewInfo("onebox", "alice", "rolling-update", time.Hour)
+	if alice.ID == bob.ID {
+		t.Fatal("the holders must be told apart by ID")
+	}
+	if err := l.Acquire(alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// bob found the lock free before alice wrote it, and his write arrives late
+	if err := l.(*metaLock).put(bob); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Refresh(alice); !errors.Is(err, ErrTakenOver) {
+		t.Errorf("alice should find the lock taken over, got %v", err)
+	}
+	if err := l.Release(alice); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := l.Status(); err != nil || holder == nil || holder.ID != bob.ID {
+		t.Errorf("alice must not release the lock of bob, got %v %v", holder, err)
+	}
+}
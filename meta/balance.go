@@ -0,0 +1,191 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/pegasus-kv/admin-cli/client"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// The interval of polling the balance progress.
+	balanceCheckInterval = 10 * time.Second
+
+	// Rebalance fails if neither the balance operations nor the balance score
+	// decreases within this duration.
+	balanceStallTimeout = 10 * time.Minute
+
+	// Rebalance finishes once the MetaServer has generated no balance operation for this
+	// duration, even if the cluster-wide score is beyond the tolerance. The MetaServer balances
+	// each table separately, which may leave the total replicas of nodes differ by more than 1.
+	balanceIdleConfirmTime = 30 * time.Second
+
+	// The cluster is considered balanced when the difference between the most and the
+	// least loaded nodes is within this percentage of the average load (at least 1).
+	balanceTolerancePercent = 5
+)
+
+// balanceScore returns how unbalanced the replicas (or only the primaries) are
+// distributed among the alive nodes, and whether it is within the tolerance.
+// A score of 0 means perfectly balanced.
+func balanceScore(nodes []*client.NodeState, primaryOnly bool) (score int, balanced bool) {
+	balanced = true
+	score += spreadScore(nodes, func(n *client.NodeState) int { return n.PrimariesNum }, &balanced)
+	if !primaryOnly {
+		score += spreadScore(nodes, func(n *client.NodeState) int { return n.ReplicaCount }, &balanced)
+	}
+	return score, balanced
+}
+
+func spreadScore(nodes []*client.NodeState, load func(*client.NodeState) int, balanced *bool) int {
+	min, max, sum, count := -1, 0, 0, 0
+	for _, n := range nodes {
+		if n.Status != admin.NodeStatus_NS_ALIVE {
+			continue
+		}
+		l := load(n)
+		if min == -1 || l < min {
+			min = l
+		}
+		if l > max {
+			max = l
+		}
+		sum += l
+		count++
+	}
+	if count == 0 {
+		return 0
+	}
+	tolerance := sum / count * balanceTolerancePercent / 100
+	if tolerance < 1 {
+		tolerance = 1
+	}
+	if max-min > tolerance {
+		*balanced = false
+	}
+	return max - min
+}
+
+// balanceProgress tracks the progress of rebalance to report the ETA and detect stall.
+type balanceProgress struct {
+	startTime time.Time
+	// the first non-zero count of balance operations
+	startOps int
+
+	minOps           int
+	minScore         int
+	lastProgressTime time.Time
+
+	// since when no balance operation is observed, zero if there is any
+	idleSince time.Time
+}
+
+func newBalanceProgress(now time.Time) *balanceProgress {
+	return &balanceProgress{
+		startTime:        now,
+		minOps:           -1,
+		minScore:         -1,
+		lastProgressTime: now,
+	}
+}
+
+// update records the current state, returns an error if rebalance has stalled.
+func (p *balanceProgress) update(ops int, score int, now time.Time) error {
+	if p.startOps == 0 && ops > 0 {
+		p.startOps = ops
+		p.startTime = now
+	}
+	if p.minOps == -1 || ops < p.minOps || p.minScore == -1 || score < p.minScore {
+		p.lastProgressTime = now
+	}
+	if p.minOps == -1 || ops < p.minOps {
+		p.minOps = ops
+	}
+	if p.minScore == -1 || score < p.minScore {
+		p.minScore = score
+	}
+	if now.Sub(p.lastProgressTime) > balanceStallTimeout {
+		return fmt.Errorf("rebalance stalled with %d balance operations and score %d for %s",
+			ops, score, now.Sub(p.lastProgressTime).Round(time.Second))
+	}
+	return nil
+}
+
+// idle records the count of balance operations, returns true once no balance operation
+// has been observed for balanceIdleConfirmTime.
+func (p *balanceProgress) idle(ops int, now time.Time) bool {
+	if ops > 0 {
+		p.idleSince = time.Time{}
+		return false
+	}
+	if p.idleSince.IsZero() {
+		p.idleSince = now
+	}
+	return now.Sub(p.idleSince) >= balanceIdleConfirmTime
+}
+
+// eta estimates the remaining time according to how fast the balance operations are done.
+// It returns 0 if unknown.
+func (p *balanceProgress) eta(ops int, now time.Time) time.Duration {
+	done := p.startOps - ops
+	if done <= 0 {
+		return 0
+	}
+	elapsed := now.Sub(p.startTime)
+	return time.Duration(int64(elapsed) / int64(done) * int64(ops)).Round(time.Second)
+}
+
+func (c *metaClient) WaitBalanced(primaryOnly bool) error {
+	progress := newBalanceProgress(time.Now())
+	for {
+		info, err := c.GetClusterInfo()
+		if err != nil {
+			return err
+		}
+		nodes, err := client.ListNodesReplicaInfo(c.meta)
+		if err != nil {
+			return err
+		}
+		ops := info.BalanceOperationCount
+		score, balanced := balanceScore(nodes, primaryOnly)
+		if ops == 0 && balanced {
+			log.Printf("cluster is balanced, balance score = %d", score)
+			return nil
+		}
+
+		now := time.Now()
+		if progress.idle(ops, now) {
+			// the MetaServer considers the cluster balanced, though it's not within our tolerance
+			log.Printf("no more balance operation is generated, leaving balance score = %d", score)
+			return nil
+		}
+		if err := progress.update(ops, score, now); err != nil {
+			return err
+		}
+		if eta := progress.eta(ops, now); eta > 0 {
+			log.Printf("still %d balance operations to do, balance score = %d, ETA %s", ops, score, eta)
+		} else {
+			log.Printf("still %d balance operations to do, balance score = %d", ops, score)
+		}
+		time.Sleep(balanceCheckInterval)
+	}
+}
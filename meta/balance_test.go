@@ -0,0 +1,231 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package meta
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/pegasus-kv/admin-cli/client"
+)
+
+func nodeState(primaries int, replicas int) *client.NodeState {
+	return &client.NodeState{Status: admin.NodeStatus_NS_ALIVE, PrimariesNum: primaries, ReplicaCount: replicas}
+}
+
+func TestBalanceScore(t *testing.T) {
+	dead := nodeState(0, 0)
+	dead.Status = admin.NodeStatus_NS_UNALIVE
+
+	tests := []struct {
+		name        string
+		nodes       []*client.NodeState
+		primaryOnly bool
+		score       int
+		balanced    bool
+	}{
+		{name: "no node", score: 0, balanced: true},
+		{name: "perfectly balanced", nodes: []*client.NodeState{nodeState(4, 12), nodeState(4, 12)}, score: 0, balanced: true},
+		{name: "differ by 1", nodes: []*client.NodeState{nodeState(4, 12), nodeState(5, 13)}, score: 2, balanced: true},
+		{name: "primaries unbalanced", nodes: []*client.NodeState{nodeState(2, 12), nodeState(6, 12)}, score: 4, balanced: false},
+		{name: "replicas unbalanced", nodes: []*client.NodeState{nodeState(4, 10), nodeState(4, 14)}, score: 4, balanced: false},
+		{
+			name:        "replicas are ignored for primary-only",
+			nodes:       []*client.NodeState{nodeState(4, 10), nodeState(4, 14)},
+			primaryOnly: true,
+			score:       0,
+			balanced:    true,
+		},
+		{
+			name:     "within 5% tolerance",
+			nodes:    []*client.NodeState{nodeState(100, 300), nodeState(104, 312)},
+			score:    16,
+			balanced: true,
+		},
+		{
+			name:     "beyond 5% tolerance",
+			nodes:    []*client.NodeState{nodeState(100, 300), nodeState(104, 330)},
+			score:    34,
+			balanced: false,
+		},
+		{name: "dead nodes are ignored", nodes: []*client.NodeState{nodeState(4, 12), nodeState(4, 12), dead}, score: 0, balanced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score, balanced := balanceScore(tt.nodes, tt.primaryOnly)
+			if score != tt.score || balanced != tt.balanced {
+				t.Errorf("expect score %d balanced %v, got %d %v", tt.score, tt.balanced, score, balanced)
+			}
+		})
+	}
+}
+
+func TestBalanceProgressStall(t *testing.T) {
+	start := time.Now()
+	p := newBalanceProgress(start)
+	if err := p.update(100, 50, start); err != nil {
+		t.Fatal(err)
+	}
+	// progress is made by either the operations or the score
+	if err := p.update(80, 50, start.Add(balanceStallTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(90, 40, start.Add(2*balanceStallTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.update(90, 45, start.Add(3*balanceStallTimeout)); err != nil {
+		t.Fatal(err)
+	}
+	err := p.update(85, 42, start.Add(3*balanceStallTimeout+time.Second))
+	if err == nil || !strings.Contains(err.Error(), "stalled") {
+		t.Errorf("expect rebalance stalled, got %v", err)
+	}
+}
+
+func TestBalanceProgressETA(t *testing.T) {
+	start := time.Now()
+	p := newBalanceProgress(start)
+	if eta := p.eta(0, start); eta != 0 {
+		t.Errorf("ETA should be unknown before any operation, got %s", eta)
+	}
+
+	// the counting starts from the first non-zero operations
+	p.update(0, 50, start)
+	p.update(100, 50, start.Add(time.Minute))
+	if eta := p.eta(100, start.Add(time.Minute)); eta != 0 {
+		t.Errorf("ETA should be unknown before any operation is done, got %s", eta)
+	}
+	p.update(75, 40, start.Add(2*time.Minute))
+	if eta := p.eta(75, start.Add(2*time.Minute)); eta != 3*time.Minute {
+		t.Errorf("expect ETA 3m, got %s", eta)
+	}
+}
+
+func TestBalanceProgressIdle(t *testing.T) {
+	start := time.Now()
+	p := newBalanceProgress(start)
+	if p.idle(0, start) {
+		t.Error("idle should be confirmed over a period")
+	}
+	if p.idle(5, start.Add(balanceIdleConfirmTime)) {
+		t.Error("not idle with balance operations")
+	}
+	// the confirmation restarts after any balance operation
+	if p.idle(0, start.Add(balanceIdleConfirmTime+time.Second)) {
+		t.Error("idle should be confirmed over a period")
+	}
+	if !p.idle(0, start.Add(2*balanceIdleConfirmTime+time.Second)) {
+		t.Error("expect idle")
+	}
+}
+
+type fakeBalancer struct {
+	calls []string
+	errs  map[string]error
+}
+
+func (b *fakeBalancer) call(name string) error {
+	b.calls = append(b.calls, name)
+	return b.errs[name]
+}
+
+func (b *fakeBalancer) SetOnlyMovePrimary(enable bool) error {
+	if enable {
+		return b.call("setOnlyMovePrimary")
+	}
+	return b.call("unsetOnlyMovePrimary")
+}
+
+func (b *fakeBalancer) SetMetaLevelLively() error {
+	return b.call("SetMetaLevelLively")
+}
+
+func (b *fakeBalancer) SetMetaLevelSteady() error {
+	return b.call("SetMetaLevelSteady")
+}
+
+func (b *fakeBalancer) WaitBalanced(primaryOnly bool) error {
+	return b.call("WaitBalanced")
+}
+
+func TestRebalance(t *testing.T) {
+	tests := []struct {
+		name        string
+		primaryOnly bool
+		failedCall  string
+		calls       []string
+	}{
+		{
+			name:  "all replicas",
+			calls: []string{"SetMetaLevelLively", "WaitBalanced", "SetMetaLevelSteady"},
+		},
+		{
+			name:        "primary only",
+			primaryOnly: true,
+			calls:       []string{"setOnlyMovePrimary", "SetMetaLevelLively", "WaitBalanced", "SetMetaLevelSteady", "unsetOnlyMovePrimary"},
+		},
+		{
+			name:        "setting primary only failed",
+			primaryOnly: true,
+			failedCall:  "setOnlyMovePrimary",
+			calls:       []string{"setOnlyMovePrimary", "unsetOnlyMovePrimary"},
+		},
+		{
+			name:        "setting lively failed",
+			primaryOnly: true,
+			failedCall:  "SetMetaLevelLively",
+			calls:       []string{"setOnlyMovePrimary", "SetMetaLevelLively", "unsetOnlyMovePrimary"},
+		},
+		{
+			name:        "balance failed",
+			primaryOnly: true,
+			failedCall:  "WaitBalanced",
+			calls:       []string{"setOnlyMovePrimary", "SetMetaLevelLively", "WaitBalanced", "SetMetaLevelSteady", "unsetOnlyMovePrimary"},
+		},
+		{
+			name:        "setting steady failed",
+			primaryOnly: true,
+			failedCall:  "SetMetaLevelSteady",
+			calls:       []string{"setOnlyMovePrimary", "SetMetaLevelLively", "WaitBalanced", "SetMetaLevelSteady", "unsetOnlyMovePrimary"},
+		},
+		{
+			name:        "unsetting primary only failed",
+			primaryOnly: true,
+			failedCall:  "unsetOnlyMovePrimary",
+			calls:       []string{"setOnlyMovePrimary", "SetMetaLevelLively", "WaitBalanced", "SetMetaLevelSteady", "unsetOnlyMovePrimary"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &fakeBalancer{errs: map[string]error{}}
+			if tt.failedCall != "" {
+				b.errs[tt.failedCall] = errors.New("injected")
+			}
+			err := RebalanceWith(b, tt.primaryOnly)
+			if (err != nil) != (tt.failedCall != "") {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if strings.Join(b.calls, ",") != strings.Join(tt.calls, ",") {
+				t.Errorf("expect calls %v, got %v", tt.calls, b.calls)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@
 package meta
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -26,12 +27,19 @@ import (
 
 	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
 	"github.com/XiaoMi/pegasus-go-client/session"
 	"github.com/pegasus-kv/admin-cli/client"
 	"github.com/pegasus-kv/admin-cli/util"
 	log "github.com/sirupsen/logrus"
 )
 
+// ErrClusterNotMatched is returned by NewMetaClient if the MetaServers belong to another cluster.
+var ErrClusterNotMatched = errors.New("cluster name and meta list aren't matched")
+
+// ErrTableNotFound is returned by GetAppEnvs if the table doesn't exist.
+var ErrTableNotFound = errors.New("table doesn't exist")
+
 type ClusterInfo struct {
 	Cluster               string
 	PrimaryMeta           string
@@ -51,11 +59,37 @@ type Meta interface {
 
 	SetMetaLevelSteady() error
 
+	SetMetaLevelLively() error
+
+	// SetMetaLevelBlind stops the MetaServer from changing any partition configuration,
+	// e.g. curing the partitions on dead nodes, which is used to shut down the cluster.
+	SetMetaLevelBlind() error
+
+	GetAddSecondaryMaxCountForOneNode() (int, error)
 	SetAddSecondaryMaxCountForOneNode(num int) error
 	ResetDefaultAddSecondaryMaxCountForOneNode() error
 
+	// SetOnlyMovePrimary makes the load balancer only balance primaries, and only by
+	// switching roles of primary and secondary, so no data is copied.
+	SetOnlyMovePrimary(enable bool) error
+
+	// SetAddSecondaryEnableFlowControl makes the load balancer add at most
+	// add_secondary_max_count_for_one_node secondaries to a node at a time.
+	SetAddSecondaryEnableFlowControl(enable bool) error
+	GetAddSecondaryEnableFlowControl() (bool, error)
+
+	// SetReplicaCopyRate limits the rate (in MB/s) of every alive ReplicaServer copying
+	// data from others, e.g. learning. 0 resets the default.
+	SetReplicaCopyRate(megabytes int) error
+
+	// GetReplicaCopyRate returns the rate (in MB/s) that the alive ReplicaServers copy data
+	// at. It fails if the rate differs among them, as it can't be restored by SetReplicaCopyRate.
+	GetReplicaCopyRate() (int, error)
+
 	SetNodeLivePercentageZero() error
 
+	// AssignSecondaryBlackList prevents the comma-separated nodes from being assigned
+	// new secondaries. An empty blacklist clears the previous one.
 	AssignSecondaryBlackList(blacklist string) error
 
 	SetAssignDelayMs(delayMs int) error
@@ -66,19 +100,54 @@ type Meta interface {
 
 	DowngradeNodeWithDetails(n *util.PegasusNode) (downgradedParts []*base.Gpid, err error)
 
-	Rebalance(bool) error
+	// Rebalance turns the MetaServer lively until the replicas are balanced among nodes.
+	// With primaryOnly, only the primaries are balanced by role switching, no data is copied.
+	Rebalance(primaryOnly bool) error
+
+	// WaitBalanced waits until the meta has no balance operation and the replicas
+	// distribution converges.
+	WaitBalanced(primaryOnly bool) error
 
 	GetClusterInfo() (*ClusterInfo, error)
 
 	ListNodes() ([]*admin.NodeInfo, error)
 
 	GetClusterReplicaInfo() (*client.ClusterReplicaInfo, error)
+
+	// ListPartitions returns the configurations of all partitions in available tables.
+	ListPartitions() ([]*replication.PartitionConfiguration, error)
+
+	// ListTables returns all available tables.
+	ListTables() ([]*admin.AppInfo, error)
+
+	// QueryBackupPolicies returns all cold backup policies along with their latest backups.
+	QueryBackupPolicies() (*admin.QueryBackupPolicyResponse, error)
+
+	// MovePrimary switches the primary of the partition from `from` to `to`.
+	// `to` must be a secondary of the partition, so no data is copied.
+	MovePrimary(gpid *base.Gpid, from *util.PegasusNode, to *util.PegasusNode) error
+
+	// GetAppEnvs returns the environment variables of the table.
+	GetAppEnvs(tableName string) (map[string]string, error)
+
+	UpdateAppEnvs(tableName string, envs map[string]string) error
+
+	DelAppEnvs(tableName string, keys []string) error
+
+	// CreateTable creates a table with 3 replicas per partition.
+	CreateTable(tableName string, partitionCount int) error
+
+	// GetTableHealthInfo returns the health information of the table.
+	GetTableHealthInfo(tableName string) (*client.TableHealthInfo, error)
 }
 
 // A MetaClient based on RPC.
 type metaClient struct {
 	meta client.Meta
 
+	// for the RPCs that are not provided by client.Meta
+	rawMeta *session.MetaManager
+
 	primaryMeta *util.PegasusNode
 }
 
@@ -86,14 +155,15 @@ type metaClient struct {
 // target cluster doesn't exactly match the name `cluster`.
 func NewMetaClient(cluster string, metaList []string) (Meta, error) {
 	c := &metaClient{
-		meta: client.NewRPCBasedMeta(metaList),
+		meta:    client.NewRPCBasedMeta(metaList),
+		rawMeta: session.NewMetaManager(metaList, session.NewNodeSession),
 	}
 	info, err := c.GetClusterInfo()
 	if err != nil {
 		return nil, err
 	}
 	if info.Cluster != cluster {
-		return nil, fmt.Errorf("cluster name and meta list aren't matched, got '%s'", info.Cluster)
+		return nil, fmt.Errorf("%w, got '%s'", ErrClusterNotMatched, info.Cluster)
 	}
 	c.primaryMeta = util.NewNodeFromTCPAddr(info.PrimaryMeta, session.NodeTypeMeta)
 	return c, nil
@@ -144,12 +214,39 @@ func (c *metaClient) ListTableHealthInfos() ([]*client.TableHealthInfo, error) {
 	return result, nil
 }
 
-func (c *metaClient) setOnlyMovePrimary() error {
+func (c *metaClient) SetOnlyMovePrimary(enable bool) error {
+	// only_move_primary takes effect on the primary balancer
+	knobs := []string{"meta.lb.only_primary_balancer", "meta.lb.only_move_primary"}
+	if !enable {
+		knobs[0], knobs[1] = knobs[1], knobs[0]
+	}
+	for _, knob := range knobs {
+		if err := client.CallCmd(c.primaryMeta, knob, []string{fmt.Sprint(enable)}).Error(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (c *metaClient) UnsetOnlyMovePrimary() error {
-	return nil
+// getKnob returns the current value of the remote command on the primary meta.
+func (c *metaClient) getKnob(name string) (string, error) {
+	result := client.CallCmd(c.primaryMeta, name, []string{})
+	if err := result.Error(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(result.RespBody()), nil
+}
+
+func (c *metaClient) GetAddSecondaryMaxCountForOneNode() (int, error) {
+	val, err := c.getKnob("meta.lb.add_secondary_max_count_for_one_node")
+	if err != nil {
+		return 0, err
+	}
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected meta.lb.add_secondary_max_count_for_one_node \"%s\"", val)
+	}
+	return num, nil
 }
 
 func (c *metaClient) SetAddSecondaryMaxCountForOneNode(num int) error {
@@ -161,12 +258,92 @@ func (c *metaClient) ResetDefaultAddSecondaryMaxCountForOneNode() error {
 	return client.CallCmd(c.primaryMeta, "meta.lb.add_secondary_max_count_for_one_node", []string{"DEFAULT"}).Error()
 }
 
+func (c *metaClient) SetAddSecondaryEnableFlowControl(enable bool) error {
+	return client.CallCmd(c.primaryMeta, "meta.lb.add_secondary_enable_flow_control", []string{fmt.Sprint(enable)}).Error()
+}
+
+func (c *metaClient) GetAddSecondaryEnableFlowControl() (bool, error) {
+	val, err := c.getKnob("meta.lb.add_secondary_enable_flow_control")
+	if err != nil {
+		return false, err
+	}
+	enable, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("unexpected meta.lb.add_secondary_enable_flow_control \"%s\"", val)
+	}
+	return enable, nil
+}
+
+func (c *metaClient) aliveReplicaNodes() ([]*util.PegasusNode, error) {
+	infos, err := c.meta.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*util.PegasusNode
+	for _, info := range infos {
+		if info.Status == admin.NodeStatus_NS_ALIVE {
+			nodes = append(nodes, util.NewNodeFromTCPAddr(info.Address.GetAddress(), session.NodeTypeReplica))
+		}
+	}
+	return nodes, nil
+}
+
+func (c *metaClient) GetReplicaCopyRate() (int, error) {
+	nodes, err := c.aliveReplicaNodes()
+	if err != nil {
+		return 0, err
+	}
+	rate := -1
+	for n, result := range client.BatchCallCmd(nodes, "nfs.max_copy_rate_megabytes", []string{}) {
+		if err := result.Error(); err != nil {
+			return 0, fmt.Errorf("failed to get nfs.max_copy_rate_megabytes of %s: %s", n.CombinedAddr(), err)
+		}
+		val := strings.TrimSpace(result.RespBody())
+		nodeRate, err := strconv.Atoi(val)
+		if err != nil {
+			return 0, fmt.Errorf("unexpected nfs.max_copy_rate_megabytes \"%s\" of %s", val, n.CombinedAddr())
+		}
+		if rate != -1 && nodeRate != rate {
+			return 0, fmt.Errorf("nfs.max_copy_rate_megabytes differs among nodes: %d and %d", rate, nodeRate)
+		}
+		rate = nodeRate
+	}
+	if rate == -1 {
+		return 0, nil
+	}
+	return rate, nil
+}
+
+func (c *metaClient) SetReplicaCopyRate(megabytes int) error {
+	rate := fmt.Sprint(megabytes)
+	if megabytes == 0 {
+		rate = "DEFAULT"
+	}
+	nodes, err := c.aliveReplicaNodes()
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for n, result := range client.BatchCallCmd(nodes, "nfs.max_copy_rate_megabytes", []string{rate}) {
+		if err := result.Error(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", n.CombinedAddr(), err))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to set nfs.max_copy_rate_megabytes on %d nodes: %s", len(failures), strings.Join(failures, "; "))
+	}
+	return nil
+}
+
 func (c *metaClient) SetNodeLivePercentageZero() error {
 	return client.CallCmd(c.primaryMeta, "meta.live_percentage", []string{"0"}).Error()
 }
 
 func (c *metaClient) AssignSecondaryBlackList(blacklist string) error {
-	return nil
+	if blacklist == "" {
+		blacklist = "clear"
+	}
+	return client.CallCmd(c.primaryMeta, "meta.lb.assign_secondary_black_list", []string{blacklist}).Error()
 }
 
 func (c *metaClient) SetAssignDelayMs(delayMs int) error {
@@ -182,54 +359,56 @@ func (c *metaClient) SetMetaLevelSteady() error {
 	return client.SetMetaLevelSteady(c.meta)
 }
 
-func (c *metaClient) setMetaLevelLively() error {
+func (c *metaClient) SetMetaLevelLively() error {
 	return client.SetMetaLevelLively(c.meta)
 }
 
+func (c *metaClient) SetMetaLevelBlind() error {
+	_, err := c.meta.MetaControl(admin.MetaFunctionLevel_fl_blind)
+	return err
+}
+
 func (c *metaClient) Rebalance(primaryOnly bool) error {
+	return RebalanceWith(c, primaryOnly)
+}
+
+// Balancer is the part of Meta that drives a rebalance.
+type Balancer interface {
+	SetOnlyMovePrimary(enable bool) error
+	SetMetaLevelLively() error
+	SetMetaLevelSteady() error
+	WaitBalanced(primaryOnly bool) error
+}
+
+// RebalanceWith implements Meta.Rebalance on the Balancer, so that a wrapper of Meta
+// can observe the knobs changed during rebalancing.
+func RebalanceWith(b Balancer, primaryOnly bool) (err error) {
 	if primaryOnly {
-		if err := c.setOnlyMovePrimary(); err != nil {
+		// the balancer is restored even if it's partially set
+		defer func() {
+			if unsetErr := b.SetOnlyMovePrimary(false); unsetErr != nil {
+				log.Errorf("failed to restore the balancer from primary-only: %s", unsetErr)
+				if err == nil {
+					err = unsetErr
+				}
+			}
+		}()
+		if err := b.SetOnlyMovePrimary(true); err != nil {
 			return err
 		}
 	}
 
-	if err := c.setMetaLevelLively(); err != nil {
+	if err := b.SetMetaLevelLively(); err != nil {
 		return err
 	}
 
-	log.Print("Wait for 3 minutes to do load balance...")
-	time.Sleep(time.Duration(180) * time.Second)
-
-	remainTimes := 1
-	for {
-		info, err := c.GetClusterInfo()
-		if err != nil {
-			return err
-		}
-		if info.BalanceOperationCount == 0 {
-			if remainTimes == 0 {
-				break
-			} else {
-				log.Print("cluster may be balanced, try wait 30 seconds...")
-				remainTimes--
-				time.Sleep(time.Duration(30) * time.Second)
-			}
-		} else {
-			log.Printf("still %d balance operations to do...", info.BalanceOperationCount)
-			time.Sleep(time.Duration(10) * time.Second)
-		}
-	}
+	log.Print("Wait for load balance to converge...")
+	balanceErr := b.WaitBalanced(primaryOnly)
 
-	if err := c.SetMetaLevelSteady(); err != nil {
+	if err := b.SetMetaLevelSteady(); err != nil {
 		return err
 	}
-
-	if primaryOnly {
-		if err := c.UnsetOnlyMovePrimary(); err != nil {
-			return err
-		}
-	}
-	return nil
+	return balanceErr
 }
 
 func (c *metaClient) getNodeState(n *util.PegasusNode) (*client.NodeState, error) {
@@ -312,3 +491,63 @@ func (c *metaClient) ListNodes() ([]*admin.NodeInfo, error) {
 func (c *metaClient) GetClusterReplicaInfo() (*client.ClusterReplicaInfo, error) {
 	return client.GetClusterReplicaInfo(c.meta)
 }
+
+func (c *metaClient) ListPartitions() ([]*replication.PartitionConfiguration, error) {
+	tbs, err := c.meta.ListAvailableApps()
+	if err != nil {
+		return nil, err
+	}
+	var result []*replication.PartitionConfiguration
+	for _, tb := range tbs {
+		resp, err := c.meta.QueryConfig(tb.AppName)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, resp.Partitions...)
+	}
+	return result, nil
+}
+
+func (c *metaClient) ListTables() ([]*admin.AppInfo, error) {
+	return c.meta.ListAvailableApps()
+}
+
+func (c *metaClient) QueryBackupPolicies() (*admin.QueryBackupPolicyResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.rawMeta.QueryBackupPolicy(ctx, &admin.QueryBackupPolicyRequest{BackupInfoCount: 1})
+}
+
+func (c *metaClient) MovePrimary(gpid *base.Gpid, from *util.PegasusNode, to *util.PegasusNode) error {
+	return c.meta.Balance(gpid, client.BalanceMovePri, from, to)
+}
+
+func (c *metaClient) GetAppEnvs(tableName string) (map[string]string, error) {
+	tbs, err := c.meta.ListAvailableApps()
+	if err != nil {
+		return nil, err
+	}
+	for _, tb := range tbs {
+		if tb.AppName == tableName {
+			return tb.Envs, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: \"%s\"", ErrTableNotFound, tableName)
+}
+
+func (c *metaClient) UpdateAppEnvs(tableName string, envs map[string]string) error {
+	return c.meta.UpdateAppEnvs(tableName, envs)
+}
+
+func (c *metaClient) DelAppEnvs(tableName string, keys []string) error {
+	return c.meta.DelAppEnvs(tableName, keys)
+}
+
+func (c *metaClient) CreateTable(tableName string, partitionCount int) error {
+	_, err := c.meta.CreateApp(tableName, nil, partitionCount)
+	return err
+}
+
+func (c *metaClient) GetTableHealthInfo(tableName string) (*client.TableHealthInfo, error) {
+	return client.GetTableHealthInfo(c.meta, tableName)
+}
@@ -20,12 +20,24 @@ package pegasus
 import (
 	"fmt"
 
+	"github.com/pegasus-kv/cluster-cli/audit"
 	"github.com/pegasus-kv/cluster-cli/deployment"
 	"github.com/pegasus-kv/cluster-cli/meta"
 )
 
 var globalAllNodes []deployment.Node
 
+// newMetaClient connects to the MetaServers, replaceable in tests.
+var newMetaClient = meta.NewMetaClient
+
+// globalAuditLog records the changes made through Meta, nil means no auditing.
+var globalAuditLog *audit.Logger
+
+// SetAuditLog makes the subsequent operations record their changes to the cluster into l.
+func SetAuditLog(l *audit.Logger) {
+	globalAuditLog = l
+}
+
 func listAndCacheAllNodes(deploy deployment.Deployment) error {
 	res, err := deploy.ListAllNodes()
 	if err != nil {
@@ -48,16 +60,30 @@ func findReplicaNode(name string) (*deployment.Node, error) {
 	return findNode(name, deployment.JobReplica)
 }
 
+// NewMeta connects to the MetaServers of the cluster, which are listed by the deployment.
+func NewMeta(cluster string, deploy deployment.Deployment) (meta.Meta, error) {
+	return newMeta(cluster, deploy)
+}
+
 // A simple wrapper around NewMetaClient.
 func newMeta(cluster string, deploy deployment.Deployment) (meta.Meta, error) {
 	if err := listAndCacheAllNodes(deploy); err != nil {
 		return nil, err
 	}
+	return connectMeta(cluster)
+}
+
+// connectMeta connects to the MetaServers in globalAllNodes.
+func connectMeta(cluster string) (meta.Meta, error) {
 	var metaList []string
 	for _, n := range globalAllNodes {
 		if n.Job == deployment.JobMeta {
 			metaList = append(metaList, n.IPPort)
 		}
 	}
-	return meta.NewMetaClient(cluster, metaList)
+	m, err := newMetaClient(cluster, metaList)
+	if err != nil {
+		return nil, err
+	}
+	return audit.WrapMeta(m, globalAuditLog), nil
 }
@@ -0,0 +1,154 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// What to do if the pre-flight checks find jobs that moving replicas may break.
+const (
+	// Refuse the operation.
+	PreflightBlock = "block"
+	// Warn and proceed.
+	PreflightWarn = "warn"
+	// Don't check at all.
+	PreflightSkip = "skip"
+)
+
+// PreflightMode applies to the operations that take replicas offline, i.e. rolling-update,
+// restart-node and remove-node.
+var PreflightMode = PreflightBlock
+
+// runningJob is a cold backup, bulk load or partition split in progress.
+type runningJob struct {
+	kind   string
+	table  string
+	detail string
+}
+
+func (j *runningJob) String() string {
+	s := fmt.Sprintf("%s of table %s", j.kind, j.table)
+	if j.detail != "" {
+		s += " (" + j.detail + ")"
+	}
+	return s
+}
+
+// checkPreflight refuses the operation if any job that moving replicas may break is running,
+// according to PreflightMode.
+func checkPreflight(meta metaApi.Meta, operation string) error {
+	switch PreflightMode {
+	case PreflightSkip:
+		return nil
+	case PreflightBlock, PreflightWarn:
+	default:
+		return fmt.Errorf("unrecognized pre-flight mode \"%s\"", PreflightMode)
+	}
+	jobs, err := findRunningJobs(meta)
+	if err != nil {
+		return fmt.Errorf("pre-flight check failed: %s", err)
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	var descs []string
+	for _, j := range jobs {
+		descs = append(descs, j.String())
+	}
+	msg := fmt.Sprintf("%d jobs are running, %s may fail them or leave partitions stuck:\n  %s",
+		len(jobs), operation, strings.Join(descs, "\n  "))
+	if PreflightMode == PreflightWarn {
+		log.Warn(msg)
+		return nil
+	}
+	return fmt.Errorf("%s\nwait for the jobs to finish, or specify --preflight=%s to proceed anyway", msg, PreflightWarn)
+}
+
+func findRunningJobs(meta metaApi.Meta) ([]*runningJob, error) {
+	tables, err := meta.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	tableNames := map[int32]string{}
+	var jobs []*runningJob
+	for _, tb := range tables {
+		tableNames[tb.AppID] = tb.AppName
+		if tb.IsBulkLoading {
+			jobs = append(jobs, &runningJob{kind: "bulk load", table: tb.AppName})
+		}
+	}
+
+	// The child partitions of a split are not registered to meta until they've caught up
+	// with their parents, their ballots remain invalid till then.
+	parts, err := meta.ListPartitions()
+	if err != nil {
+		return nil, err
+	}
+	splitting := map[int32]int{}
+	for _, part := range parts {
+		if part.Ballot < 0 {
+			splitting[part.Pid.Appid]++
+		}
+	}
+	for appID, count := range splitting {
+		jobs = append(jobs, &runningJob{
+			kind:   "partition split",
+			table:  tableNames[appID],
+			detail: fmt.Sprintf("%d child partitions not ready", count),
+		})
+	}
+
+	resp, err := meta.QueryBackupPolicies()
+	if err != nil {
+		// cold backup may be disabled in the cluster
+		log.Warnf("unable to check the cold backups: %s", err)
+	} else {
+		for i, policy := range resp.Policys {
+			if i >= len(resp.BackupInfos) {
+				break
+			}
+			for _, backup := range resp.BackupInfos[i] {
+				if backup.EndTimeMs != 0 {
+					continue
+				}
+				for _, appID := range backup.AppIds {
+					jobs = append(jobs, &runningJob{
+						kind:   "cold backup",
+						table:  tableNames[appID],
+						detail: fmt.Sprintf("policy %s, backup %d", policy.PolicyName, backup.BackupID),
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].table == jobs[j].table {
+			return jobs[i].kind < jobs[j].kind
+		}
+		return jobs[i].table < jobs[j].table
+	})
+	return jobs, nil
+}
@@ -0,0 +1,113 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+)
+
+func TestFindRunningJobs(t *testing.T) {
+	m := newFakeMeta()
+	m.tables = []*admin.AppInfo{
+		{AppID: 1, AppName: "temp"},
+		{AppID: 2, AppName: "stat", IsBulkLoading: true},
+		{AppID: 3, AppName: "users"},
+	}
+	m.partitions = []*replication.PartitionConfiguration{
+		{Pid: &base.Gpid{Appid: 1, PartitionIndex: 0}, Ballot: 3},
+		{Pid: &base.Gpid{Appid: 3, PartitionIndex: 4}, Ballot: -1},
+		{Pid: &base.Gpid{Appid: 3, PartitionIndex: 5}, Ballot: -1},
+	}
+	m.backups = &admin.QueryBackupPolicyResponse{
+		Policys: []*admin.PolicyEntry{{PolicyName: "daily"}, {PolicyName: "weekly"}},
+		BackupInfos: [][]*admin.BackupEntry{
+			{{BackupID: 10, EndTimeMs: 100, AppIds: []int32{1}}, {BackupID: 11, AppIds: []int32{1}}},
+			// finished
+			{{BackupID: 20, EndTimeMs: 200, AppIds: []int32{3}}},
+		},
+	}
+
+	jobs, err := findRunningJobs(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descs []string
+	for _, j := range jobs {
+		descs = append(descs, j.String())
+	}
+	expected := []string{
+		"bulk load of table stat",
+		"cold backup of table temp (policy daily, backup 11)",
+		"partition split of table users (2 child partitions not ready)",
+	}
+	if !equalCalls(descs, expected) {
+		t.Errorf("unexpected jobs %v", descs)
+	}
+}
+
+func TestCheckPreflight(t *testing.T) {
+	defer func(old string) { PreflightMode = old }(PreflightMode)
+
+	bulkLoading := []*admin.AppInfo{{AppID: 1, AppName: "temp", IsBulkLoading: true}}
+	tests := []struct {
+		name   string
+		mode   string
+		tables []*admin.AppInfo
+		errs   map[string]error
+		// the expected error, empty means proceeding
+		err string
+	}{
+		{name: "no job", mode: PreflightBlock},
+		{name: "blocked", mode: PreflightBlock, tables: bulkLoading, err: "--preflight=warn"},
+		{name: "warned", mode: PreflightWarn, tables: bulkLoading},
+		{name: "skipped", mode: PreflightSkip, tables: bulkLoading,
+			errs: map[string]error{"ListTables": errors.New("timeout")}},
+		{name: "unknown mode", mode: "ignore", err: "unrecognized pre-flight mode"},
+		{name: "tables unavailable", mode: PreflightWarn,
+			errs: map[string]error{"ListTables": errors.New("timeout")}, err: "pre-flight check failed: timeout"},
+		{name: "partitions unavailable", mode: PreflightBlock,
+			errs: map[string]error{"ListPartitions": errors.New("timeout")}, err: "pre-flight check failed: timeout"},
+		// cold backup may be disabled in the cluster
+		{name: "backups unavailable", mode: PreflightBlock,
+			errs: map[string]error{"QueryBackupPolicies": errors.New("not supported")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			PreflightMode = tt.mode
+			m := newFakeMeta()
+			m.tables = tt.tables
+			for method, err := range tt.errs {
+				m.errs[method] = err
+			}
+
+			err := checkPreflight(m, "bouncing nodes")
+			if tt.err == "" && err != nil {
+				t.Fatal(err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
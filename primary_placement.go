@@ -0,0 +1,130 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/util"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// primaryPlacement records the partitions whose primaries were on the node,
+// before the node is drained.
+type primaryPlacement struct {
+	node  *util.PegasusNode
+	gpids []base.Gpid
+}
+
+func snapshotPrimaries(meta metaApi.Meta, node *util.PegasusNode) (*primaryPlacement, error) {
+	parts, err := meta.ListPartitions()
+	if err != nil {
+		return nil, err
+	}
+	p := &primaryPlacement{node: node}
+	for _, part := range parts {
+		if part.Primary.GetAddress() == node.TCPAddr() {
+			p.gpids = append(p.gpids, *part.Pid)
+		}
+	}
+	log.Debugf("%d primaries recorded on %s", len(p.gpids), node.CombinedAddr())
+	return p, nil
+}
+
+// restore switches the primaries back to the node, where they must have become secondaries
+// after the node returns. It returns the number of partitions that could not be restored.
+func (p *primaryPlacement) restore(meta metaApi.Meta) (int, error) {
+	if len(p.gpids) == 0 {
+		return 0, nil
+	}
+	parts, err := meta.ListPartitions()
+	if err != nil {
+		return len(p.gpids), err
+	}
+	partMap := map[base.Gpid]*replication.PartitionConfiguration{}
+	for _, part := range parts {
+		partMap[*part.Pid] = part
+	}
+
+	recorded := map[base.Gpid]bool{}
+	nodes := map[string]*util.PegasusNode{}
+	unrestored := 0
+	for _, gpid := range p.gpids {
+		recorded[gpid] = true
+		part, ok := partMap[gpid]
+		if !ok {
+			// the table may have been dropped
+			continue
+		}
+		if part.Primary.GetAddress() == p.node.TCPAddr() {
+			continue
+		}
+		if part.Primary.GetRawAddress() == 0 || !isSecondaryOf(part, p.node) {
+			unrestored++
+			continue
+		}
+		addr := part.Primary.GetAddress()
+		from, ok := nodes[addr]
+		if !ok {
+			from = util.NewNodeFromTCPAddr(addr, session.NodeTypeReplica)
+			nodes[addr] = from
+		}
+		if err := meta.MovePrimary(part.Pid, from, p.node); err != nil {
+			return len(p.gpids), err
+		}
+	}
+
+	// wait for the proposals to be applied
+	notBack := 0
+	_, err = waitFor(func() (bool, error) {
+		parts, err := meta.ListPartitions()
+		if err != nil {
+			return false, err
+		}
+		notBack = 0
+		for _, part := range parts {
+			if !recorded[*part.Pid] {
+				continue
+			}
+			if part.Primary.GetAddress() != p.node.TCPAddr() {
+				notBack++
+			}
+		}
+		return notBack <= unrestored, nil
+	}, time.Second, 30)
+	if err != nil {
+		return len(p.gpids), err
+	}
+	if notBack > unrestored {
+		unrestored = notBack
+	}
+	return unrestored, nil
+}
+
+func isSecondaryOf(part *replication.PartitionConfiguration, node *util.PegasusNode) bool {
+	for _, sec := range part.Secondaries {
+		if sec.GetAddress() == node.TCPAddr() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/base"
+	"github.com/XiaoMi/pegasus-go-client/idl/replication"
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/util"
+)
+
+func partition(appID int32, index int32, primary string, secondaries ...string) *replication.PartitionConfiguration {
+	part := &replication.PartitionConfiguration{
+		Pid:     &base.Gpid{Appid: appID, PartitionIndex: index},
+		Primary: toRPCAddress(primary),
+	}
+	for _, sec := range secondaries {
+		part.Secondaries = append(part.Secondaries, toRPCAddress(sec))
+	}
+	return part
+}
+
+// newPlacementTest snapshots the primaries of 1.0, 1.2 and 2.0 on 127.0.0.1:34801.
+func newPlacementTest(t *testing.T) (*fakeMeta, *primaryPlacement) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802", "127.0.0.1:34803")
+	m.partitions = []*replication.PartitionConfiguration{
+		partition(1, 0, "127.0.0.1:34801", "127.0.0.1:34802"),
+		partition(1, 1, "127.0.0.1:34802", "127.0.0.1:34801"),
+		partition(1, 2, "127.0.0.1:34801", "127.0.0.1:34803"),
+		partition(2, 0, "127.0.0.1:34801", "127.0.0.1:34802"),
+	}
+	p, err := snapshotPrimaries(m, util.NewNodeFromTCPAddr("127.0.0.1:34801", session.NodeTypeReplica))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.gpids) != 3 {
+		t.Fatalf("unexpected primaries recorded %v", p.gpids)
+	}
+	return m, p
+}
+
+func primaryOf(m *fakeMeta, appID int32, index int32) string {
+	parts, _ := m.ListPartitions()
+	for _, part := range parts {
+		if part.Pid.Appid == appID && part.Pid.PartitionIndex == index {
+			return part.Primary.GetAddress()
+		}
+	}
+	return ""
+}
+
+func TestRestorePrimaries(t *testing.T) {
+	m, p := newPlacementTest(t)
+	// the primaries have moved away while the node was restarting, except 2.0
+	m.partitions = []*replication.PartitionConfiguration{
+		partition(1, 0, "127.0.0.1:34802", "127.0.0.1:34801"),
+		partition(1, 1, "127.0.0.1:34802", "127.0.0.1:34801"),
+		partition(1, 2, "127.0.0.1:34803", "127.0.0.1:34801"),
+		partition(2, 0, "127.0.0.1:34801", "127.0.0.1:34802"),
+	}
+
+	unrestored, err := p.restore(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unrestored != 0 {
+		t.Errorf("unexpected %d partitions unrestored", unrestored)
+	}
+	expected := []string{
+		"MovePrimary 1.0 127.0.0.1:34802 127.0.0.1:34801",
+		"MovePrimary 1.2 127.0.0.1:34803 127.0.0.1:34801",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	for _, gpid := range p.gpids {
+		if primary := primaryOf(m, gpid.Appid, gpid.PartitionIndex); primary != "127.0.0.1:34801" {
+			t.Errorf("the primary of %d.%d is %s", gpid.Appid, gpid.PartitionIndex, primary)
+		}
+	}
+	if primary := primaryOf(m, 1, 1); primary != "127.0.0.1:34802" {
+		t.Errorf("the primary of 1.1 is not recorded but moved to %s", primary)
+	}
+}
+
+func TestRestorePrimariesPartially(t *testing.T) {
+	m, p := newPlacementTest(t)
+	m.partitions = []*replication.PartitionConfiguration{
+		partition(1, 0, "127.0.0.1:34802", "127.0.0.1:34801"),
+		partition(1, 1, "127.0.0.1:34802", "127.0.0.1:34801"),
+		// the node is not a secondary of 1.2 yet
+		partition(1, 2, "127.0.0.1:34803", "127.0.0.1:34802"),
+		// table 2 has been dropped
+	}
+
+	unrestored, err := p.restore(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unrestored != 1 {
+		t.Errorf("unexpected %d partitions unrestored", unrestored)
+	}
+	if !equalCalls(m.recorded(), []string{"MovePrimary 1.0 127.0.0.1:34802 127.0.0.1:34801"}) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	if primary := primaryOf(m, 1, 2); primary != "127.0.0.1:34803" {
+		t.Errorf("the primary of 1.2 is moved to %s", primary)
+	}
+}
+
+func TestRestorePrimariesFailed(t *testing.T) {
+	m, p := newPlacementTest(t)
+	m.partitions = []*replication.PartitionConfiguration{
+		partition(1, 0, "127.0.0.1:34802", "127.0.0.1:34801"),
+		partition(1, 2, "127.0.0.1:34803", "127.0.0.1:34801"),
+	}
+	m.errs["MovePrimary"] = errors.New("timeout")
+
+	unrestored, err := p.restore(m)
+	if err == nil {
+		t.Fatal("expect restoring to fail")
+	}
+	if unrestored != len(p.gpids) {
+		t.Errorf("all partitions should be reported unrestored, got %d", unrestored)
+	}
+	// stops at the first failure
+	if !equalCalls(m.recorded(), []string{"MovePrimary 1.0 127.0.0.1:34802 127.0.0.1:34801"}) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+}
+
+func TestRestoreNoPrimaries(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801")
+	m.partitions = []*replication.PartitionConfiguration{partition(1, 0, "127.0.0.1:34802", "127.0.0.1:34801")}
+	p, err := snapshotPrimaries(m, util.NewNodeFromTCPAddr("127.0.0.1:34801", session.NodeTypeReplica))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the partitions are not even listed
+	m.errs["ListPartitions"] = errors.New("timeout")
+	if unrestored, err := p.restore(m); unrestored != 0 || err != nil {
+		t.Errorf("unexpected restoring result %d, %v", unrestored, err)
+	}
+}
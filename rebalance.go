@@ -0,0 +1,42 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	log "github.com/sirupsen/logrus"
+)
+
+// Rebalance implements the rebalance command.
+func Rebalance(cluster string, deploy deployment.Deployment, primaryOnly bool) error {
+	meta, err := newMeta(cluster, deploy)
+	if err != nil {
+		return err
+	}
+
+	if primaryOnly {
+		log.Print("Balancing primaries of the cluster...")
+	} else {
+		log.Print("Balancing replicas of the cluster...")
+	}
+	if err := meta.Rebalance(primaryOnly); err != nil {
+		return err
+	}
+	log.Print("Rebalance done")
+	return nil
+}
@@ -18,40 +18,100 @@
 package pegasus
 
 import (
-	"errors"
-	"strings"
 	"time"
 
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/util"
 	"github.com/pegasus-kv/cluster-cli/deployment"
 	"github.com/pegasus-kv/cluster-cli/meta"
 	log "github.com/sirupsen/logrus"
 )
 
-func RemoveNode(cluster string, deploy deployment.Deployment, metaList string, node string) error {
-	meta, err := newMeta(cluster, deploy)
+// RemoveNodes implements the remove-node command. The nodes are removed one by one,
+// the cluster is waited to be healthy after each node is stopped.
+func RemoveNodes(cluster string, deploy deployment.Deployment, nodeNames []string, limits WatchdogLimits) error {
+	meta, nodes, err := prepareRemoval(cluster, deploy, nodeNames)
 	if err != nil {
 		return err
 	}
 
-	node, ok := findReplicaNode(name)
-	if !ok {
-		return errors.New("replica node '" + name + "' not found")
+	w, err := startWatchdog(meta, limits)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	down := newDowngrader(meta, deploy)
+	for _, node := range nodes {
+		if err := removeNode(deploy, meta, down, w, node); err != nil {
+			w.Stop()
+			revertCluster(meta)
+			return err
+		}
 	}
-	if err := client.AssignSecondaryBlackList(strings.Join(addrs, ",")); err != nil {
+	return nil
+}
+
+// RemoveNodesAtOnce implements remove-node --all-at-once. The replicas on all nodes are
+// migrated onto the surviving nodes in one pass, so that no data is moved to a node that
+// is removed later. The nodes are stopped after the cluster becomes healthy.
+func RemoveNodesAtOnce(cluster string, deploy deployment.Deployment, nodeNames []string, limits WatchdogLimits) error {
+	meta, nodes, err := prepareRemoval(cluster, deploy, nodeNames)
+	if err != nil {
 		return err
 	}
-	if err := client.SetNodeLivePercentageZero(); err != nil {
+
+	w, err := startWatchdog(meta, limits)
+	if err != nil {
 		return err
 	}
+	defer w.Stop()
 
-	if err := removeNode(deploy, client, node); err != nil {
+	if err := removeNodesAtOnce(deploy, meta, newDowngrader(meta, deploy), w, nodes); err != nil {
+		w.Stop()
+		revertCluster(meta)
 		return err
 	}
 	return nil
 }
 
-func removeNode(deploy deployment.Deployment, metaClient meta.Meta, node deployment.Node) error {
-	log.Printf("Stopping replica node %s of %s ...", node.Name(), node.IPPort())
+// prepareRemoval resolves the nodes to remove, and prevents them from being assigned
+// new replicas.
+func prepareRemoval(cluster string, deploy deployment.Deployment, nodeNames []string) (meta.Meta, []*deployment.Node, error) {
+	metaClient, err := newMeta(cluster, deploy)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkPreflight(metaClient, "removing nodes"); err != nil {
+		return nil, nil, err
+	}
+
+	var nodes []*deployment.Node
+	var addrs []string
+	for _, name := range nodeNames {
+		node, err := findReplicaNode(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		nodes = append(nodes, node)
+		addrs = append(addrs, node.IPPort)
+	}
+	// keep the drained nodes in blacklist
+	drained, err := loadDrainedSet(metaClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := metaClient.AssignSecondaryBlackList(drained.blacklist(addrs...)); err != nil {
+		return nil, nil, err
+	}
+	if err := metaClient.SetNodeLivePercentageZero(); err != nil {
+		return nil, nil, err
+	}
+	return metaClient, nodes, nil
+}
+
+func removeNode(deploy deployment.Deployment, metaClient meta.Meta, down Downgrader, w *watchdog, nInfo *deployment.Node) error {
+	log.Printf("Stopping replica node %s of %s ...", nInfo.Name, nInfo.IPPort)
 	if err := metaClient.SetMetaLevelSteady(); err != nil {
 		return err
 	}
@@ -59,81 +119,119 @@ func removeNode(deploy deployment.Deployment, metaClient meta.Meta, node deploym
 		return err
 	}
 
-	// migrate node
-	log.Print("Migrating primary replicas out of node...")
-	if err := metaClient.MigratePrimariesOut(node); err != nil {
+	// the node is expected to be dead since now
+	w.Operate(nInfo.IPPort)
+
+	log.Print("Downgrading replicas on node...")
+	node := util.NewNodeFromTCPAddr(nInfo.IPPort, session.NodeTypeReplica)
+	if err := down.Downgrade(node); err != nil {
+		return err
+	}
+	if err := w.Err(); err != nil {
 		return err
 	}
 
-	// downgrade node and kill partition
-	log.Print("Downgrading replicas on node...")
-	gpids, err := metaClient.Downgrade(node.IPPort())
-	if err != nil {
+	log.Print("Stop node by deployment...")
+	if err := deploy.StopNode(*nInfo); err != nil {
 		return err
 	}
-	// wait for rep_count == 0
-	log.Printf("Wait %s to downgrade done...", node.IPPort())
-	if _, err := waitFor(func() (bool, error) {
-		val := 0
-		nodes, err := metaClient.ListNodes()
-		if err != nil {
-			return false, err
-		}
-		for _, n := range nodes {
-			if n.IPPort() == node.IPPort() {
-				val = n.ReplicaCount
-				break
+	log.Print("Stop node by deployment done")
+	time.Sleep(time.Second)
+
+	log.Print("Wait cluster to become healthy...")
+	if err := waitClusterHealthy(metaClient, w); err != nil {
+		return err
+	}
+	log.Print("Cluster becomes healthy")
+
+	if err := metaClient.ResetDefaultAssignDelayMs(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func removeNodesAtOnce(deploy deployment.Deployment, metaClient meta.Meta, down Downgrader, w *watchdog, nodes []*deployment.Node) error {
+	if err := metaClient.SetMetaLevelSteady(); err != nil {
+		return err
+	}
+	if err := metaClient.SetAssignDelayMs(10); err != nil {
+		return err
+	}
+
+	for i, nInfo := range nodes {
+		// A partition may have replicas on several of the nodes. Its replica downgraded from
+		// the previous node must be cured before the next one is downgraded.
+		if i > 0 {
+			log.Printf("Wait partitions on %s to become healthy...", nInfo.IPPort)
+			if err := waitNodePartitionsHealthy(metaClient, w, nInfo.IPPort); err != nil {
+				return err
 			}
 		}
-		if val == 0 {
-			log.Print("Downgrade done.")
-			return true, nil
+
+		// the node is expected to be dead since now
+		w.Operate(nInfo.IPPort)
+
+		log.Printf("Downgrading replicas on node %s of %s (%d/%d)...", nInfo.Name, nInfo.IPPort, i+1, len(nodes))
+		node := util.NewNodeFromTCPAddr(nInfo.IPPort, session.NodeTypeReplica)
+		if err := down.Downgrade(node); err != nil {
+			return err
+		}
+		if err := w.Err(); err != nil {
+			return err
 		}
-		log.Printf("Still %d replicas left on %s", val, node.IPPort())
-		return false, nil
-	}, time.Second, 0); err != nil {
+	}
+
+	log.Print("Wait cluster to become healthy...")
+	if err := waitClusterHealthy(metaClient, w); err != nil {
 		return err
 	}
-	time.Sleep(time.Second)
+	log.Print("Cluster becomes healthy")
 
-	remoteCmdClient := client.NewRemoteCmdClient(node.IPPort())
-	for _, gpid := range gpids {
-		if _, err := remoteCmdClient.KillPartition(gpid); err != nil {
+	for _, nInfo := range nodes {
+		log.Printf("Stopping replica node %s of %s by deployment...", nInfo.Name, nInfo.IPPort)
+		if err := deploy.StopNode(*nInfo); err != nil {
 			return err
 		}
 	}
+	log.Printf("Stopped %d nodes by deployment", len(nodes))
 
-	log.Print("Stop node by deployment...")
-	if err := deploy.StopNode(node); err != nil {
-		return err
-	}
-	log.Print("Stop node by deployment done")
-	time.Sleep(time.Second)
+	return metaClient.ResetDefaultAssignDelayMs()
+}
 
-	log.Print("Wait cluster to become healthy...")
-	if _, err := waitFor(func() (bool, error) {
-		infos, err := metaClient.ListTableHealthInfos()
+// waitNodePartitionsHealthy blocks until every partition with a replica on the node has
+// all of its replicas, or the watchdog fires.
+func waitNodePartitionsHealthy(metaClient meta.Meta, w *watchdog, addr string) error {
+	for {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		parts, err := metaClient.ListPartitions()
 		if err != nil {
-			return false, err
+			return err
 		}
-		count := 0
-		for _, info := range infos {
-			if info.PartitionCount != info.FullyHealthy {
-				count += info.Unhealthy
+		unhealthy := 0
+		for _, part := range parts {
+			onNode := part.Primary.GetAddress() == addr
+			for _, sec := range part.Secondaries {
+				if sec.GetAddress() == addr {
+					onNode = true
+				}
+			}
+			if !onNode {
+				continue
+			}
+			replicas := len(part.Secondaries)
+			if part.Primary.GetRawAddress() != 0 {
+				replicas++
+			}
+			if int32(replicas) < part.MaxReplicaCount {
+				unhealthy++
 			}
 		}
-		if count != 0 {
-			log.Printf("Cluster not healthy, unhealthy_partition_count = %d", count)
-			return false, nil
+		if unhealthy == 0 {
+			return nil
 		}
-		log.Print("Cluster becomes healthy")
-		return true, nil
-	}, time.Duration(10)*time.Second, 0); err != nil {
-		return err
+		log.Debugf("%d partitions on %s are not healthy", unhealthy, addr)
+		time.Sleep(time.Second)
 	}
-
-	if err := metaClient.ResetDefaultAssignDelayMs(); err != nil {
-		return err
-	}
-	return nil
 }
@@ -0,0 +1,155 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+)
+
+func newRemovalTest(t *testing.T) (*fakeMeta, *fakeDeployment, *fakeDowngrader) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802", "127.0.0.1:34803")
+	useFakeMeta(t, m)
+	down := &fakeDowngrader{meta: m}
+	useFakeDowngrader(t, down)
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"),
+		replicaNode("3", "127.0.0.1:34803"))
+	return m, d, down
+}
+
+func TestRemoveNodesAtOnce(t *testing.T) {
+	m, d, _ := newRemovalTest(t)
+
+	if err := RemoveNodesAtOnce("onebox", d, []string{"1", "2"}, DefaultWatchdogLimits); err != nil {
+		t.Fatal(err)
+	}
+	// all nodes are downgraded before any is stopped
+	expected := []string{
+		"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34802",
+		"SetNodeLivePercentageZero",
+		"SetMetaLevelSteady",
+		"SetAssignDelayMs 10",
+		"Downgrade 127.0.0.1:34801",
+		"Downgrade 127.0.0.1:34802",
+		"ResetDefaultAssignDelayMs",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	if !equalCalls(d.recorded(), []string{"StopNode replica 1", "StopNode replica 2"}) {
+		t.Errorf("unexpected deployment calls %v", d.recorded())
+	}
+}
+
+func TestRemoveNodesAtOnceFailed(t *testing.T) {
+	revertCalls := []string{"AssignSecondaryBlackList", "ResetDefaultAddSecondaryMaxCountForOneNode",
+		"ResetDefaultAssignDelayMs", "SetMetaLevelLively"}
+	tests := []struct {
+		name   string
+		nodes  []string
+		limits WatchdogLimits
+		// inject the failure
+		prepare func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader)
+		// the expected calls before reverting, nil metaCalls are not checked as they depend on
+		// when the watchdog fires
+		metaCalls   []string
+		deployCalls []string
+		reverted    bool
+	}{
+		{
+			name:      "unknown node",
+			nodes:     []string{"1", "4"},
+			prepare:   func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {},
+			metaCalls: []string{},
+		},
+		{
+			name:  "blocked by pre-flight check",
+			nodes: []string{"1", "2"},
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				m.tables = []*admin.AppInfo{{AppID: 1, AppName: "temp", IsBulkLoading: true}}
+			},
+			metaCalls: []string{},
+		},
+		{
+			name:    "node fails to be downgraded",
+			nodes:   []string{"1", "2"},
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) { down.err = errors.New("timeout") },
+			metaCalls: []string{"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34802", "SetNodeLivePercentageZero",
+				"SetMetaLevelSteady", "SetAssignDelayMs 10", "Downgrade 127.0.0.1:34801"},
+			reverted: true,
+		},
+		{
+			name:  "cluster fails to become healthy",
+			nodes: []string{"1", "2"},
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				m.errs["GetClusterReplicaInfo"] = errors.New("timeout")
+			},
+			metaCalls: []string{"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34802", "SetNodeLivePercentageZero",
+				"SetMetaLevelSteady", "SetAssignDelayMs 10", "Downgrade 127.0.0.1:34801", "Downgrade 127.0.0.1:34802"},
+			reverted: true,
+		},
+		{
+			name:  "first node fails to stop",
+			nodes: []string{"1", "2"},
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				d.errs["StopNode replica 1"] = errors.New("no permission")
+			},
+			metaCalls: []string{"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34802", "SetNodeLivePercentageZero",
+				"SetMetaLevelSteady", "SetAssignDelayMs 10", "Downgrade 127.0.0.1:34801", "Downgrade 127.0.0.1:34802"},
+			deployCalls: []string{"StopNode replica 1"},
+			reverted:    true,
+		},
+		{
+			name:   "aborted by watchdog",
+			nodes:  []string{"1", "2"},
+			limits: WatchdogLimits{Interval: time.Millisecond},
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				m.unhealthy = []int32{5}
+			},
+			reverted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, d, down := newRemovalTest(t)
+			tt.prepare(m, d, down)
+
+			if err := RemoveNodesAtOnce("onebox", d, tt.nodes, tt.limits); err == nil {
+				t.Fatal("expect removal to fail")
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			calls := m.recorded()
+			reverted := len(calls) >= len(revertCalls) &&
+				equalCalls(calls[len(calls)-len(revertCalls):], revertCalls)
+			if reverted {
+				calls = calls[:len(calls)-len(revertCalls)]
+			}
+			if tt.metaCalls != nil && !equalCalls(calls, tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", calls)
+			}
+			if reverted != tt.reverted {
+				t.Errorf("expect reverted %v, got meta calls %v", tt.reverted, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/session"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/deployment"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// The seconds to wait for a newly started node to join the cluster.
+const nodeJoinTimeoutSecs = 300
+
+type replacer struct {
+	meta     metaApi.Meta
+	deploy   deployment.Deployment
+	down     Downgrader
+	watchdog *watchdog
+
+	oldNode *deployment.Node
+	newNode *deployment.Node
+	drained *drainedSet
+
+	// the progress, used to decide how to revert
+	newStarted    bool
+	oldDowngraded bool
+	oldStopped    bool
+}
+
+// ReplaceNode implements the replace-node command. It brings up the replica node `newName`,
+// moves all replicas off `oldName`, stops `oldName`, and then rebalances the cluster.
+// If any step before rebalancing fails, the cluster is reverted to use the old node.
+func ReplaceNode(cluster string, deploy deployment.Deployment, oldName string, newName string, limits WatchdogLimits) error {
+	if oldName == newName {
+		return fmt.Errorf("node %s can't be replaced with itself", oldName)
+	}
+	meta, err := newMeta(cluster, deploy)
+	if err != nil {
+		return err
+	}
+	oldNode, err := findReplicaNode(oldName)
+	if err != nil {
+		return err
+	}
+	newNode, err := findReplicaNode(newName)
+	if err != nil {
+		return err
+	}
+	drained, err := loadDrainedSet(meta)
+	if err != nil {
+		return err
+	}
+
+	if err := meta.SetMetaLevelSteady(); err != nil {
+		return err
+	}
+	w, err := startWatchdog(meta, limits)
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+
+	r := &replacer{
+		meta:     meta,
+		deploy:   deploy,
+		down:     newDowngrader(meta, deploy),
+		watchdog: w,
+		oldNode:  oldNode,
+		newNode:  newNode,
+		drained:  drained,
+	}
+	if err := r.replace(); err != nil {
+		log.Errorf("Replacing %s with %s failed: %s", oldNode.IPPort, newNode.IPPort, err)
+		r.revert()
+		return err
+	}
+	return r.finish()
+}
+
+func (r *replacer) replace() error {
+	r.watchdog.Operate(r.newNode.IPPort)
+
+	log.Printf("Starting new node %s by deployment...", r.newNode.IPPort)
+	if err := r.deploy.StartNode(*r.newNode); err != nil {
+		return err
+	}
+	r.newStarted = true
+	log.Print("Starting node by deployment done")
+
+	log.Printf("Wait %s to join the cluster...", r.newNode.IPPort)
+	joined, err := waitFor(func() (bool, error) {
+		if err := r.watchdog.Err(); err != nil {
+			return false, err
+		}
+		return isNodeAlive(r.meta, r.newNode.IPPort)
+	}, time.Second, nodeJoinTimeoutSecs)
+	if err != nil {
+		return err
+	}
+	if !joined {
+		return fmt.Errorf("node %s didn't join the cluster in %d seconds", r.newNode.IPPort, nodeJoinTimeoutSecs)
+	}
+	r.watchdog.Release(r.newNode.IPPort)
+
+	if err := r.meta.SetAssignDelayMs(10); err != nil {
+		return err
+	}
+
+	// keep the meta from curing the downgraded replicas back onto the old node
+	r.oldDowngraded = true
+	if err := r.meta.AssignSecondaryBlackList(r.drained.blacklist(r.oldNode.IPPort)); err != nil {
+		return err
+	}
+
+	log.Printf("Migrating replicas out of old node %s...", r.oldNode.IPPort)
+	r.watchdog.Operate(r.oldNode.IPPort)
+	old := util.NewNodeFromTCPAddr(r.oldNode.IPPort, session.NodeTypeReplica)
+	if err := r.down.Downgrade(old); err != nil {
+		return err
+	}
+	if err := r.watchdog.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Stopping old node %s by deployment...", r.oldNode.IPPort)
+	r.oldStopped = true
+	if err := r.deploy.StopNode(*r.oldNode); err != nil {
+		return err
+	}
+	log.Print("Stop node by deployment done")
+
+	log.Print("Wait cluster to become healthy...")
+	if err := waitClusterHealthy(r.meta, r.watchdog); err != nil {
+		return err
+	}
+	log.Print("Cluster becomes healthy")
+
+	return r.meta.ResetDefaultAssignDelayMs()
+}
+
+// revert brings the cluster back to the state before replacement as far as possible:
+// the old node is kept serving, and the new node is stopped only if it hasn't taken any data.
+func (r *replacer) revert() {
+	r.watchdog.Stop()
+	if r.oldStopped {
+		log.Printf("Restarting old node %s by deployment...", r.oldNode.IPPort)
+		if err := r.deploy.StartNode(*r.oldNode); err != nil {
+			log.Errorf("failed to restart old node %s: %s", r.oldNode.IPPort, err)
+		}
+	}
+	if r.newStarted && !r.oldDowngraded {
+		log.Printf("Stopping new node %s by deployment...", r.newNode.IPPort)
+		if err := r.deploy.StopNode(*r.newNode); err != nil {
+			log.Errorf("failed to stop new node %s: %s", r.newNode.IPPort, err)
+		}
+	}
+	revertCluster(r.meta)
+}
+
+// finish lifts the throttles of a successful replacement: the old node is no longer blacklisted
+// or drained, the replicas are rebalanced onto the new node, and the meta is left lively.
+func (r *replacer) finish() error {
+	r.watchdog.Stop()
+	if r.drained.remove(r.oldNode) {
+		if err := r.drained.save(r.meta); err != nil {
+			return err
+		}
+	}
+	if err := r.meta.AssignSecondaryBlackList(r.drained.blacklist()); err != nil {
+		return err
+	}
+	log.Print("Rebalancing the cluster...")
+	if err := r.meta.Rebalance(false); err != nil {
+		return err
+	}
+	// Rebalance leaves the meta steady
+	return r.meta.SetMetaLevelLively()
+}
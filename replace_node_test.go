@@ -0,0 +1,180 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestReplacer(t *testing.T, m *fakeMeta, down *fakeDowngrader) (*replacer, *fakeDeployment) {
+	oldNode := replicaNode("1", "127.0.0.1:34801")
+	newNode := replicaNode("4", "127.0.0.1:34804")
+	d := newFakeDeployment(m, oldNode, newNode)
+
+	m.setDrained(replicaNode("3", "127.0.0.1:34803"))
+	drained, err := loadDrainedSet(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := startWatchdog(m, WatchdogLimits{MaxUnhealthyPartitions: -1, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+	return &replacer{
+		meta:     m,
+		deploy:   d,
+		down:     down,
+		watchdog: w,
+		oldNode:  &oldNode,
+		newNode:  &newNode,
+		drained:  drained,
+	}, d
+}
+
+func TestReplace(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	r, d := newTestReplacer(t, m, &fakeDowngrader{meta: m})
+
+	if err := r.replace(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.finish(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"SetAssignDelayMs 10",
+		"AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803",
+		"Downgrade 127.0.0.1:34801",
+		"ResetDefaultAssignDelayMs",
+		"AssignSecondaryBlackList 127.0.0.1:34803",
+		"Rebalance false",
+		"SetMetaLevelLively",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+	if !equalCalls(d.recorded(), []string{"StartNode replica 4", "StopNode replica 1"}) {
+		t.Errorf("unexpected deployment calls %v", d.recorded())
+	}
+}
+
+func TestReplaceDrainedNode(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	r, _ := newTestReplacer(t, m, &fakeDowngrader{meta: m})
+	m.setDrained(replicaNode("1", "127.0.0.1:34801"), replicaNode("3", "127.0.0.1:34803"))
+	r.drained, _ = loadDrainedSet(m)
+
+	if err := r.replace(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.finish(); err != nil {
+		t.Fatal(err)
+	}
+	drained, err := loadDrainedSet(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if drained.blacklist() != "127.0.0.1:34803" {
+		t.Errorf("the old node should no longer be drained: %s", drained.blacklist())
+	}
+	calls := m.recorded()
+	if !equalCalls(calls[len(calls)-3:], []string{"AssignSecondaryBlackList 127.0.0.1:34803", "Rebalance false", "SetMetaLevelLively"}) {
+		t.Errorf("unexpected meta calls %v", calls)
+	}
+}
+
+func TestReplaceFailed(t *testing.T) {
+	tests := []struct {
+		name string
+		// inject the failure
+		prepare func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader)
+		// the expected calls to deployment and meta during reverting
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name: "new node fails to start",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				d.errs["StartNode"] = errors.New("no package")
+			},
+			deployCalls: []string{"StartNode replica 4"},
+			metaCalls: []string{"AssignSecondaryBlackList 127.0.0.1:34803",
+				"ResetDefaultAddSecondaryMaxCountForOneNode", "ResetDefaultAssignDelayMs", "SetMetaLevelLively"},
+		},
+		{
+			name: "old node fails to be blacklisted",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				m.errs["AssignSecondaryBlackList"] = errors.New("timeout")
+			},
+			deployCalls: []string{"StartNode replica 4"},
+			metaCalls: []string{"SetAssignDelayMs 10", "AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803",
+				"AssignSecondaryBlackList 127.0.0.1:34803", "ResetDefaultAddSecondaryMaxCountForOneNode",
+				"ResetDefaultAssignDelayMs", "SetMetaLevelLively"},
+		},
+		{
+			name:        "old node fails to be downgraded",
+			prepare:     func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) { down.err = errors.New("timeout") },
+			deployCalls: []string{"StartNode replica 4"},
+			metaCalls: []string{"SetAssignDelayMs 10", "AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803",
+				"Downgrade 127.0.0.1:34801", "AssignSecondaryBlackList 127.0.0.1:34803",
+				"ResetDefaultAddSecondaryMaxCountForOneNode", "ResetDefaultAssignDelayMs", "SetMetaLevelLively"},
+		},
+		{
+			name: "cluster fails to become healthy",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) {
+				m.errs["GetClusterReplicaInfo"] = errors.New("timeout")
+			},
+			deployCalls: []string{"StartNode replica 4", "StopNode replica 1", "StartNode replica 1"},
+			metaCalls: []string{"SetAssignDelayMs 10", "AssignSecondaryBlackList 127.0.0.1:34801,127.0.0.1:34803",
+				"Downgrade 127.0.0.1:34801", "AssignSecondaryBlackList 127.0.0.1:34803",
+				"ResetDefaultAddSecondaryMaxCountForOneNode", "ResetDefaultAssignDelayMs", "SetMetaLevelLively"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+			down := &fakeDowngrader{meta: m}
+			r, d := newTestReplacer(t, m, down)
+			tt.prepare(m, d, down)
+
+			if err := r.replace(); err == nil {
+				t.Fatal("expect replacing to fail")
+			}
+			r.revert()
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
+
+func TestReplaceWithItself(t *testing.T) {
+	d := newFakeDeployment(nil, replicaNode("1", "127.0.0.1:34801"))
+	if err := ReplaceNode("onebox", d, "1", "1", DefaultWatchdogLimits); err == nil {
+		t.Fatal("expect error when replacing a node with itself")
+	}
+	if len(d.recorded()) != 0 {
+		t.Errorf("no node should be operated: %v", d.recorded())
+	}
+}
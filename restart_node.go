@@ -0,0 +1,43 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"github.com/pegasus-kv/cluster-cli/deployment"
+)
+
+// RestartNodes implements the restart-node command. It restarts the replica nodes one by one
+// with the same care as rolling-update, but keeps their versions unchanged.
+func RestartNodes(cluster string, deploy deployment.Deployment, nodeNames []string, limits WatchdogLimits) error {
+	u, err := PrepareRollingUpdate(cluster, deploy, limits)
+	if err != nil {
+		return err
+	}
+	defer u.watchdog.Stop()
+
+	for _, name := range nodeNames {
+		node, err := findReplicaNode(name)
+		if err != nil {
+			return err
+		}
+		if err := u.RestartNode(node); err != nil {
+			return err
+		}
+	}
+	return u.Finish()
+}
@@ -0,0 +1,142 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestUpdater(t *testing.T, m *fakeMeta, d *fakeDeployment, down *fakeDowngrader) *Updater {
+	w, err := startWatchdog(m, WatchdogLimits{MaxUnhealthyPartitions: -1, Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(w.Stop)
+	return &Updater{meta: m, deploy: d, down: down, watchdog: w}
+}
+
+func TestRestartNode(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	node := replicaNode("1", "127.0.0.1:34801")
+	d := newFakeDeployment(m, node)
+	u := newTestUpdater(t, m, d, &fakeDowngrader{meta: m})
+
+	if err := u.RestartNode(&node); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	// the deployment without Restarter restarts the node by stop and start
+	if !equalCalls(d.recorded(), []string{"StopNode replica 1", "StartNode replica 1"}) {
+		t.Errorf("unexpected deployment calls %v", d.recorded())
+	}
+	expected := []string{
+		"SetAddSecondaryMaxCountForOneNode 0",
+		"Downgrade 127.0.0.1:34801",
+		"SetAddSecondaryMaxCountForOneNode 100",
+		"ResetDefaultAddSecondaryMaxCountForOneNode",
+	}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected meta calls %v", m.recorded())
+	}
+}
+
+func TestRestartNodeFailed(t *testing.T) {
+	revertCalls := []string{"AssignSecondaryBlackList", "ResetDefaultAddSecondaryMaxCountForOneNode",
+		"ResetDefaultAssignDelayMs", "SetMetaLevelLively"}
+	tests := []struct {
+		name string
+		// inject the failure
+		prepare     func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader, w *watchdog)
+		deployCalls []string
+		metaCalls   []string
+	}{
+		{
+			name: "downgrade failed",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader, w *watchdog) {
+				down.err = errors.New("timeout")
+			},
+			deployCalls: nil,
+			metaCalls:   append([]string{"SetAddSecondaryMaxCountForOneNode 0", "Downgrade 127.0.0.1:34801"}, revertCalls...),
+		},
+		{
+			name: "restart failed",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader, w *watchdog) {
+				d.errs["StartNode"] = errors.New("no package")
+			},
+			// the cluster is not left unable to add secondaries
+			deployCalls: []string{"StopNode replica 1", "StartNode replica 1"},
+			metaCalls:   append([]string{"SetAddSecondaryMaxCountForOneNode 0", "Downgrade 127.0.0.1:34801"}, revertCalls...),
+		},
+		{
+			name: "aborted by watchdog",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader, w *watchdog) {
+				w.err = errors.New("1 unexpected dead nodes")
+			},
+			deployCalls: nil,
+			metaCalls:   revertCalls,
+		},
+		{
+			name: "meta is unavailable",
+			prepare: func(m *fakeMeta, d *fakeDeployment, down *fakeDowngrader, w *watchdog) {
+				m.errs["ListPartitions"] = errors.New("timeout")
+			},
+			deployCalls: nil,
+			metaCalls:   append([]string{"SetAddSecondaryMaxCountForOneNode 0"}, revertCalls...),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+			node := replicaNode("1", "127.0.0.1:34801")
+			d := newFakeDeployment(m, node)
+			down := &fakeDowngrader{meta: m}
+			u := newTestUpdater(t, m, d, down)
+			tt.prepare(m, d, down, u.watchdog)
+
+			if err := u.RestartNode(&node); err == nil {
+				t.Fatal("expect restarting to fail")
+			}
+			if !equalCalls(d.recorded(), tt.deployCalls) {
+				t.Errorf("unexpected deployment calls %v", d.recorded())
+			}
+			if !equalCalls(m.recorded(), tt.metaCalls) {
+				t.Errorf("unexpected meta calls %v", m.recorded())
+			}
+		})
+	}
+}
+
+func TestRestartNodes(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	useFakeMeta(t, m)
+	d := newFakeDeployment(m, replicaNode("1", "127.0.0.1:34801"), replicaNode("2", "127.0.0.1:34802"))
+
+	err := RestartNodes("onebox", d, []string{"3"}, DefaultWatchdogLimits)
+	if err == nil || len(d.recorded()) != 0 {
+		t.Errorf("expect error on unknown node without operating any, got %v %v", err, d.recorded())
+	}
+
+	m.cluster = "other"
+	if err := RestartNodes("onebox", d, []string{"1"}, DefaultWatchdogLimits); err == nil {
+		t.Error("expect error on mismatched cluster")
+	}
+}
@@ -19,9 +19,7 @@ package pegasus
 
 import (
 	"fmt"
-	"time"
 
-	"github.com/XiaoMi/pegasus-go-client/idl/admin"
 	"github.com/XiaoMi/pegasus-go-client/session"
 	"github.com/pegasus-kv/admin-cli/util"
 	"github.com/pegasus-kv/cluster-cli/deployment"
@@ -34,23 +32,69 @@ type Updater struct {
 	deploy deployment.Deployment
 
 	down Downgrader
+
+	watchdog *watchdog
+
+	// the number of primaries that were not moved back to their nodes after update
+	unrestoredPrimaries int
+}
+
+// RollingUpdateNodes implements the rolling-update command. If no node is specified,
+// all nodes are updated in the order of replica, meta, collector.
+func RollingUpdateNodes(cluster string, deploy deployment.Deployment, nodeNames []string, limits WatchdogLimits) error {
+	u, err := PrepareRollingUpdate(cluster, deploy, limits)
+	if err != nil {
+		return err
+	}
+	defer u.watchdog.Stop()
+
+	if len(nodeNames) == 0 {
+		for _, job := range []deployment.JobType{deployment.JobReplica, deployment.JobMeta, deployment.JobCollector} {
+			for _, n := range globalAllNodes {
+				if n.Job != job {
+					continue
+				}
+				node := n
+				if err := u.UpdateNode(&node); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		for _, name := range nodeNames {
+			if err := u.FindAndUpdateNode(name, deployment.JobReplica); err != nil {
+				return err
+			}
+		}
+	}
+
+	return u.Finish()
 }
 
-func PrepareRollingUpdate(cluster string, deploy deployment.Deployment) (*Updater, error) {
+func PrepareRollingUpdate(cluster string, deploy deployment.Deployment, limits WatchdogLimits) (*Updater, error) {
 	meta, err := newMeta(cluster, deploy)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkPreflight(meta, "bouncing nodes"); err != nil {
+		return nil, err
+	}
 
 	// preparation: stop automatic rebalance
 	if err := meta.SetMetaLevelSteady(); err != nil {
 		return nil, err
 	}
 
+	w, err := startWatchdog(meta, limits)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Updater{
-		meta:   meta,
-		deploy: deploy,
-		down:   newDowngrader(meta, deploy),
+		meta:     meta,
+		deploy:   deploy,
+		down:     newDowngrader(meta, deploy),
+		watchdog: w,
 	}, nil
 }
 
@@ -62,43 +106,79 @@ func (u *Updater) FindAndUpdateNode(nodeName string, jobType deployment.JobType)
 	return u.UpdateNode(node)
 }
 
-// rolling-update a single node.
+// rolling-update a single node. The cluster is reverted if the update fails.
 func (u *Updater) UpdateNode(node *deployment.Node) error {
+	return u.bounceNode(node, "Rolling update", u.deploy.RollingUpdate)
+}
+
+// RestartNode gracefully restarts a single node without changing its version.
+func (u *Updater) RestartNode(node *deployment.Node) error {
+	return u.bounceNode(node, "Restart", func(n deployment.Node) error {
+		return deployment.RestartNode(u.deploy, n)
+	})
+}
+
+// bounceNode takes the node out of service, performs the deployment action that brings
+// the node down and up, and waits for the node to serve again. The cluster is reverted
+// if the node fails to be bounced, so that it's not left throttled.
+func (u *Updater) bounceNode(node *deployment.Node, actionName string, action func(deployment.Node) error) error {
+	if err := u.updateNode(node, actionName, action); err != nil {
+		u.watchdog.Stop()
+		revertCluster(u.meta)
+		return err
+	}
+	return nil
+}
+
+func (u *Updater) updateNode(node *deployment.Node, actionName string, action func(deployment.Node) error) error {
+	if err := u.watchdog.Err(); err != nil {
+		return err
+	}
+	u.watchdog.Operate(node.IPPort)
+	defer u.watchdog.Release(node.IPPort)
+
 	switch node.Job {
 	case deployment.JobCollector:
-		return u.updateStatelessNode(node)
+		return u.updateStatelessNode(node, action)
 	case deployment.JobMeta:
-		return u.updateStatelessNode(node)
+		return u.updateStatelessNode(node, action)
 	case deployment.JobReplica:
-		return u.updateSingleReplicaNode(node)
+		return u.updateSingleReplicaNode(node, actionName, action)
 	default:
 		return fmt.Errorf("unknown node type: \"%s\"", node.Job)
 	}
 }
 
 // Stateless node means any node other than ReplicaServer. Simple rolling is fine.
-func (u *Updater) updateStatelessNode(node *deployment.Node) error {
-	return u.deploy.RollingUpdate(*node)
+func (u *Updater) updateStatelessNode(node *deployment.Node, action func(deployment.Node) error) error {
+	return action(*node)
 }
 
-func (u *Updater) updateSingleReplicaNode(nInfo *deployment.Node) error {
+func (u *Updater) updateSingleReplicaNode(nInfo *deployment.Node, actionName string, action func(deployment.Node) error) error {
 	log.Debug("set meta.lb.add_secondary_max_count_for_one_node to 0")
 	if err := u.meta.SetAddSecondaryMaxCountForOneNode(0); err != nil {
 		return err
 	}
 
 	node := util.NewNodeFromTCPAddr(nInfo.IPPort, session.NodeTypeReplica)
+	primaries, err := snapshotPrimaries(u.meta, node)
+	if err != nil {
+		return err
+	}
 	if err := u.down.Downgrade(node); err != nil {
 		return err
 	}
+	if err := u.watchdog.Err(); err != nil {
+		return err
+	}
 
-	log.Print("Rolling update by deployment...")
-	if err := u.deploy.RollingUpdate(*nInfo); err != nil {
+	log.Printf("%s by deployment...", actionName)
+	if err := action(*nInfo); err != nil {
 		return err
 	}
-	log.Print("Rolling update by deployment done")
+	log.Printf("%s by deployment done", actionName)
 
-	if err := u.waitNodeAlive(node); err != nil {
+	if err := waitNodeAlive(u.meta, u.watchdog, node); err != nil {
 		return err
 	}
 
@@ -106,49 +186,33 @@ func (u *Updater) updateSingleReplicaNode(nInfo *deployment.Node) error {
 		return err
 	}
 
-	if err := u.waitClusterHealthy(); err != nil {
+	if err := waitClusterHealthy(u.meta, u.watchdog); err != nil {
 		return err
 	}
+
+	log.Printf("Moving %d primaries back to %s...", len(primaries.gpids), node.CombinedAddr())
+	unrestored, err := primaries.restore(u.meta)
+	if err != nil {
+		log.Errorf("failed to move primaries back: %s", err)
+	}
+	if unrestored > 0 {
+		log.Warnf("%d primaries were not moved back to %s", unrestored, node.CombinedAddr())
+	}
+	u.unrestoredPrimaries += unrestored
 	return nil
 }
 
 func (u *Updater) Finish() error {
+	u.watchdog.Stop()
 	if err := u.meta.ResetDefaultAddSecondaryMaxCountForOneNode(); err != nil {
 		return err
 	}
-	return u.meta.Rebalance(false)
-}
-
-func (u *Updater) waitNodeAlive(n *util.PegasusNode) error {
-	for {
-		nodes, err := u.meta.ListNodes()
-		if err != nil {
-			return err
-		}
-		for _, ninfo := range nodes {
-			if ninfo.Address.GetAddress() == n.TCPAddr() {
-				if ninfo.Status == admin.NodeStatus_NS_ALIVE {
-					return nil
-				}
-			}
-		}
-		time.Sleep(time.Second)
-	}
-}
-
-func (u *Updater) waitClusterHealthy() error {
-	for {
-		clusterInfo, err := u.meta.GetClusterReplicaInfo()
-		if err != nil {
-			return err
-		}
-		unhealthy := int32(0)
-		for _, tb := range clusterInfo.Tables {
-			unhealthy += tb.Unhealthy
-		}
-		if unhealthy == int32(0) {
-			return nil
-		}
-		time.Sleep(time.Second)
+	// The replicas return to their original nodes after update, and so do the primaries
+	// in most cases. Only the primaries need to be balanced if some were not restored.
+	if u.unrestoredPrimaries == 0 {
+		log.Print("All primaries are restored, rebalance is unnecessary")
+		return nil
 	}
+	log.Printf("%d primaries were not restored, balancing primaries...", u.unrestoredPrimaries)
+	return u.meta.Rebalance(true)
 }
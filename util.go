@@ -18,8 +18,12 @@
 package pegasus
 
 import (
-	"github.com/pegasus-kv/cluster-cli/meta"
 	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	"github.com/pegasus-kv/admin-cli/util"
+	"github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
 )
 
 // TODO(wutao): refactor to `waitFor(checker func() (bool, error), timeout time.Duration)`
@@ -45,3 +49,55 @@ func waitFor(checker func() (bool, error), interval time.Duration, timeout int)
 func waitForNodeBecome(meta meta.Meta, checker func()) error {
 	return nil
 }
+
+// waitNodeAlive blocks until the node is reported alive by meta, or the watchdog fires.
+func waitNodeAlive(meta meta.Meta, w *watchdog, n *util.PegasusNode) error {
+	for {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		alive, err := isNodeAlive(meta, n.TCPAddr())
+		if err != nil {
+			return err
+		}
+		if alive {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func isNodeAlive(meta meta.Meta, addr string) (bool, error) {
+	nodes, err := meta.ListNodes()
+	if err != nil {
+		return false, err
+	}
+	for _, ninfo := range nodes {
+		if ninfo.Address.GetAddress() == addr {
+			return ninfo.Status == admin.NodeStatus_NS_ALIVE, nil
+		}
+	}
+	return false, nil
+}
+
+// waitClusterHealthy blocks until all partitions are fully healthy, or the watchdog fires.
+func waitClusterHealthy(meta meta.Meta, w *watchdog) error {
+	for {
+		if err := w.Err(); err != nil {
+			return err
+		}
+		clusterInfo, err := meta.GetClusterReplicaInfo()
+		if err != nil {
+			return err
+		}
+		unhealthy := int32(0)
+		for _, tb := range clusterInfo.Tables {
+			unhealthy += tb.Unhealthy
+		}
+		if unhealthy == int32(0) {
+			return nil
+		}
+		log.Debugf("cluster not healthy, unhealthy_partition_count = %d", unhealthy)
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/XiaoMi/pegasus-go-client/idl/admin"
+	metaApi "github.com/pegasus-kv/cluster-cli/meta"
+	log "github.com/sirupsen/logrus"
+)
+
+// WatchdogLimits are the thresholds of cluster degradation that are tolerated
+// while an operation is in progress. A negative value disables the check.
+type WatchdogLimits struct {
+	// The maximum number of partitions that are not fully healthy.
+	MaxUnhealthyPartitions int
+
+	// The maximum number of dead nodes, excluding the nodes being operated and
+	// those that were already dead when the operation began.
+	MaxDeadNodes int
+
+	// The maximum number of partitions that have at most one live replica.
+	MaxSingleReplicaPartitions int
+
+	// How often the cluster is polled.
+	Interval time.Duration
+}
+
+// DefaultWatchdogLimits aborts the operation as soon as another node dies, or any
+// partition is left with a single replica. Unhealthy partitions are not limited
+// by default, since downgrading a node always makes its partitions unhealthy.
+var DefaultWatchdogLimits = WatchdogLimits{
+	MaxUnhealthyPartitions:     -1,
+	MaxDeadNodes:               0,
+	MaxSingleReplicaPartitions: 0,
+	Interval:                   10 * time.Second,
+}
+
+// watchdog polls the cluster in background during an operation. Once the cluster
+// degrades beyond the limits, it records the reason, and the operation is expected
+// to abort and revert at its next checkpoint via `Err`.
+//
+// A nil watchdog is valid and never fires.
+type watchdog struct {
+	meta   metaApi.Meta
+	limits WatchdogLimits
+
+	mu sync.Mutex
+	// nodes being operated, they are expected to be dead for a while
+	operating map[string]bool
+	// nodes that were dead before the watchdog starts
+	deadBefore map[string]bool
+	err        error
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func startWatchdog(meta metaApi.Meta, limits WatchdogLimits) (*watchdog, error) {
+	nodes, err := meta.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	w := &watchdog{
+		meta:       meta,
+		limits:     limits,
+		operating:  map[string]bool{},
+		deadBefore: map[string]bool{},
+		stopCh:     make(chan struct{}),
+		doneCh:     make(chan struct{}),
+	}
+	for _, n := range nodes {
+		if n.Status != admin.NodeStatus_NS_ALIVE {
+			addr := n.GetAddress().GetAddress()
+			log.Warnf("node %s is already dead, the watchdog ignores it", addr)
+			w.deadBefore[addr] = true
+		}
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *watchdog) loop() {
+	defer close(w.doneCh)
+	interval := w.limits.Interval
+	if interval <= 0 {
+		interval = DefaultWatchdogLimits.Interval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		err := w.check()
+		if err == nil {
+			continue
+		}
+		log.Errorf("watchdog: %s, aborting the operation", err)
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+		return
+	}
+}
+
+func (w *watchdog) check() error {
+	info, err := w.meta.GetClusterReplicaInfo()
+	if err != nil {
+		// a transient failure of meta is not a degradation of the cluster
+		log.Warnf("watchdog: unable to query cluster: %s", err)
+		return nil
+	}
+
+	unhealthy, singleReplica := 0, 0
+	for _, tb := range info.Tables {
+		unhealthy += int(tb.Unhealthy)
+		singleReplica += int(tb.WriteUnhealthy)
+	}
+
+	w.mu.Lock()
+	var dead []string
+	for _, n := range info.Nodes {
+		if n.Status != admin.NodeStatus_NS_ALIVE && !w.operating[n.IPPort] && !w.deadBefore[n.IPPort] {
+			dead = append(dead, n.IPPort)
+		}
+	}
+	w.mu.Unlock()
+
+	if exceeds(len(dead), w.limits.MaxDeadNodes) {
+		return fmt.Errorf("%d unexpected dead nodes %v exceeds the limit %d", len(dead), dead, w.limits.MaxDeadNodes)
+	}
+	if exceeds(singleReplica, w.limits.MaxSingleReplicaPartitions) {
+		return fmt.Errorf("%d partitions with a single live replica exceeds the limit %d",
+			singleReplica, w.limits.MaxSingleReplicaPartitions)
+	}
+	if exceeds(unhealthy, w.limits.MaxUnhealthyPartitions) {
+		return fmt.Errorf("%d unhealthy partitions exceeds the limit %d", unhealthy, w.limits.MaxUnhealthyPartitions)
+	}
+	return nil
+}
+
+func exceeds(val int, limit int) bool {
+	return limit >= 0 && val > limit
+}
+
+// Operate excludes the node from the dead-node check until Release.
+func (w *watchdog) Operate(addr string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.operating[addr] = true
+}
+
+func (w *watchdog) Release(addr string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.operating, addr)
+}
+
+// Err returns non-nil if the cluster has degraded beyond the limits.
+func (w *watchdog) Err() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return fmt.Errorf("aborted by watchdog: %s", w.err)
+	}
+	return nil
+}
+
+func (w *watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	select {
+	case <-w.stopCh:
+	default:
+		close(w.stopCh)
+	}
+	<-w.doneCh
+}
+
+// revertCluster turns the cluster back to normal state after a failed or aborted operation,
+// so that the MetaServer can cure the partitions by itself. The secondary blacklist
+// is restored to the drained nodes.
+func revertCluster(meta metaApi.Meta) {
+	log.Warn("Reverting the cluster to normal state...")
+	if drained, err := loadDrainedSet(meta); err != nil {
+		log.Errorf("failed to load the drained nodes: %s", err)
+	} else if err := meta.AssignSecondaryBlackList(drained.blacklist()); err != nil {
+		log.Errorf("failed to restore the secondary blacklist: %s", err)
+	}
+	if err := meta.ResetDefaultAddSecondaryMaxCountForOneNode(); err != nil {
+		log.Errorf("failed to reset meta.lb.add_secondary_max_count_for_one_node: %s", err)
+	}
+	if err := meta.ResetDefaultAssignDelayMs(); err != nil {
+		log.Errorf("failed to reset meta.lb.assign_delay_ms: %s", err)
+	}
+	if err := meta.SetMetaLevelLively(); err != nil {
+		log.Errorf("failed to set meta level lively: %s", err)
+	}
+}
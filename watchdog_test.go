@@ -0,0 +1,192 @@
+/*
+ * Licensed to the Apache Software Foundation (ASF) under one or more
+ * contributor license agreements.  See the NOTICE file distributed with
+ * this work for additional information regarding copyright ownership.
+ * The ASF licenses this file to You under the Apache License, Version 2.0
+ * (the "License"); you may not use this file except in compliance with
+ * the License.  You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package pegasus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchdogCheck(t *testing.T) {
+	noLimits := WatchdogLimits{MaxUnhealthyPartitions: -1, MaxDeadNodes: -1, MaxSingleReplicaPartitions: -1}
+	tests := []struct {
+		name   string
+		limits WatchdogLimits
+		// prepare runs after the watchdog starts
+		prepare func(m *fakeMeta, w *watchdog)
+		errMsg  string
+	}{
+		{
+			name:   "healthy",
+			limits: DefaultWatchdogLimits,
+		},
+		{
+			name:    "unexpected dead node",
+			limits:  DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) { m.setAlive("127.0.0.1:34802", false) },
+			errMsg:  "unexpected dead nodes",
+		},
+		{
+			name:   "operated node is dead",
+			limits: DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) {
+				w.Operate("127.0.0.1:34802")
+				m.setAlive("127.0.0.1:34802", false)
+			},
+		},
+		{
+			name:   "released node is dead",
+			limits: DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) {
+				w.Operate("127.0.0.1:34802")
+				w.Release("127.0.0.1:34802")
+				m.setAlive("127.0.0.1:34802", false)
+			},
+			errMsg: "unexpected dead nodes",
+		},
+		{
+			name:    "dead nodes within limit",
+			limits:  WatchdogLimits{MaxUnhealthyPartitions: -1, MaxDeadNodes: 1, MaxSingleReplicaPartitions: -1},
+			prepare: func(m *fakeMeta, w *watchdog) { m.setAlive("127.0.0.1:34802", false) },
+		},
+		{
+			name:    "single replica partitions",
+			limits:  DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) { m.singleReplica = 1 },
+			errMsg:  "single live replica",
+		},
+		{
+			name:    "unhealthy partitions unlimited",
+			limits:  DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) { m.unhealthy = []int32{5} },
+		},
+		{
+			name:    "unhealthy partitions exceed limit",
+			limits:  WatchdogLimits{MaxUnhealthyPartitions: 4, MaxDeadNodes: -1, MaxSingleReplicaPartitions: -1},
+			prepare: func(m *fakeMeta, w *watchdog) { m.unhealthy = []int32{5} },
+			errMsg:  "unhealthy partitions",
+		},
+		{
+			name:   "nothing is limited",
+			limits: noLimits,
+			prepare: func(m *fakeMeta, w *watchdog) {
+				m.setAlive("127.0.0.1:34802", false)
+				m.singleReplica = 3
+				m.unhealthy = []int32{5}
+			},
+		},
+		{
+			name:    "meta is unavailable",
+			limits:  DefaultWatchdogLimits,
+			prepare: func(m *fakeMeta, w *watchdog) { m.errs["GetClusterReplicaInfo"] = errors.New("timeout") },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802", "127.0.0.1:34803")
+			// the polling never fires in test
+			tt.limits.Interval = time.Hour
+			w, err := startWatchdog(m, tt.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Stop()
+			if tt.prepare != nil {
+				tt.prepare(m, w)
+			}
+
+			err = w.check()
+			if tt.errMsg == "" && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)) {
+				t.Errorf("expect error containing %q, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestWatchdogIgnoresNodesDeadBefore(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	m.setAlive("127.0.0.1:34802", false)
+	w, err := startWatchdog(m, WatchdogLimits{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	if err := w.check(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatchdogFires(t *testing.T) {
+	m := newFakeMeta("127.0.0.1:34801", "127.0.0.1:34802")
+	limits := DefaultWatchdogLimits
+	limits.Interval = time.Millisecond
+	w, err := startWatchdog(m, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setAlive("127.0.0.1:34802", false)
+
+	fired, _ := waitFor(func() (bool, error) { return w.Err() != nil, nil }, time.Millisecond, 5000)
+	if !fired {
+		t.Fatal("the watchdog doesn't fire")
+	}
+	w.Stop()
+	// stopping twice is harmless
+	w.Stop()
+
+	m.unhealthy = []int32{0}
+	if err := waitClusterHealthy(m, w); err == nil || !strings.Contains(err.Error(), "aborted by watchdog") {
+		t.Errorf("waiting should be aborted by the watchdog, got %v", err)
+	}
+}
+
+func TestStartWatchdogFailed(t *testing.T) {
+	m := newFakeMeta()
+	m.errs["ListNodes"] = errors.New("timeout")
+	if _, err := startWatchdog(m, DefaultWatchdogLimits); err == nil {
+		t.Error("expect error if the nodes can't be listed")
+	}
+}
+
+func TestNilWatchdog(t *testing.T) {
+	var w *watchdog
+	w.Operate("127.0.0.1:34801")
+	w.Release("127.0.0.1:34801")
+	w.Stop()
+	if err := w.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRevertCluster(t *testing.T) {
+	m := newFakeMeta()
+	m.setDrained(replicaNode("3", "127.0.0.1:34803"))
+	// reverting goes on even if some steps fail
+	m.errs["ResetDefaultAssignDelayMs"] = errors.New("timeout")
+	revertCluster(m)
+	expected := []string{"AssignSecondaryBlackList 127.0.0.1:34803", "ResetDefaultAddSecondaryMaxCountForOneNode",
+		"ResetDefaultAssignDelayMs", "SetMetaLevelLively"}
+	if !equalCalls(m.recorded(), expected) {
+		t.Errorf("unexpected calls %v", m.recorded())
+	}
+}